@@ -16,10 +16,17 @@ import (
 
 	"github.com/musannif-md/musannif/internal/config"
 	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/fsck"
+	"github.com/musannif-md/musannif/internal/handlers"
 	"github.com/musannif-md/musannif/internal/logger"
 	"github.com/musannif-md/musannif/internal/middlewares"
+	"github.com/musannif-md/musannif/internal/notify"
+	"github.com/musannif-md/musannif/internal/oidc"
+	"github.com/musannif-md/musannif/internal/publish"
 	"github.com/musannif-md/musannif/internal/routes"
+	"github.com/musannif-md/musannif/internal/storage"
 	"github.com/musannif-md/musannif/internal/utils"
+	"github.com/musannif-md/musannif/internal/watcher"
 )
 
 func initialize() error {
@@ -37,12 +44,33 @@ func initialize() error {
 	}
 
 	utils.SetJwtKeys(config.Cfg.Secrets.JWT_ACCESS_SECRET, config.Cfg.Secrets.JWT_REFRESH_SECRET)
+	utils.SetTokenLifetimes(config.Cfg.Auth.AccessTokenTTL, config.Cfg.Auth.RefreshTokenTTL)
+	utils.SetMinPasswordLength(config.Cfg.Auth.MinPasswordLength)
 
 	err = db.InitDb(config.Cfg.App.SqliteDirectory)
 	if err != nil {
 		log.Fatalf("error initializing db: %v\n", err)
 	}
 
+	utils.SetRevocationChecker(db.CheckTokenRevoked)
+
+	err = storage.Initialize(&config.Cfg)
+	if err != nil {
+		log.Fatalf("error initializing note storage: %v\n", err)
+	}
+
+	publish.Initialize(&config.Cfg)
+
+	err = oidc.Initialize(&config.Cfg)
+	if err != nil {
+		log.Fatalf("error initializing single sign-on: %v\n", err)
+	}
+
+	err = notify.Initialize(&config.Cfg)
+	if err != nil {
+		log.Fatalf("error initializing notifier: %v\n", err)
+	}
+
 	return nil
 }
 
@@ -54,22 +82,59 @@ func main() {
 
 	userSignup := flag.Bool("signup", false, "Create user")
 	serve := flag.Bool("serve", false, "Start server")
+	runFsck := flag.Bool("fsck", false, "Reconcile note rows in the database with note storage")
+	dryRun := flag.Bool("dry-run", false, "With `-fsck`, only report what would be changed")
 	username := flag.String("username", "", "Username for user")
 	password := flag.String("password", "", "Password for user")
+	role := flag.String("role", utils.RoleMember, "Role for user: admin, member or guest")
 	flag.Parse()
 
-	if !*userSignup && !*serve {
-		fmt.Fprintln(os.Stderr, "No command specified. Use `--signup` or `-serve`")
+	if !*userSignup && !*serve && !*runFsck {
+		fmt.Fprintln(os.Stderr, "No command specified. Use `--signup`, `-fsck` or `-serve`")
 		os.Exit(1)
 	}
 
+	if *runFsck {
+		report, err := fsck.Run(*dryRun)
+		if err != nil {
+			log.Fatalf("error during fsck: %v\n", err)
+		}
+
+		for _, f := range report.AdoptedFiles {
+			fmt.Printf("adopted file without a row: %s\n", f)
+		}
+		for _, r := range report.DroppedRows {
+			fmt.Printf("dropped row without a file: %s\n", r)
+		}
+		for _, f := range report.RemovedStaged {
+			fmt.Printf("removed leftover staged content: %s\n", f)
+		}
+		for _, a := range report.RemovedAttachments {
+			fmt.Printf("removed attachment without a row: %s\n", a)
+		}
+
+		if *dryRun {
+			fmt.Println("dry run; nothing was changed")
+		}
+
+		return
+	}
+
 	if *userSignup {
 		if *username == "" || *password == "" {
 			log.Fatal("username and password are required for signup")
 			os.Exit(1)
 		}
 
-		err := db.SignupUser(*username, *password, "user")
+		if err := utils.ValidatePassword(*username, *password); err != nil {
+			log.Fatalf("error creating user: %v\n", err)
+		}
+
+		if !utils.ValidRole(*role) {
+			log.Fatalf("unknown role %q\n", *role)
+		}
+
+		err := db.SignupUser(*username, *password, *role)
 		if err != nil {
 			log.Fatalf("error creating user: %v\n", err)
 			os.Exit(1)
@@ -99,6 +164,18 @@ func run(ctx context.Context) error {
 		}
 	}()
 
+	if config.Cfg.App.WatchNotes {
+		if config.Cfg.Storage.Backend != "" && config.Cfg.Storage.Backend != storage.BackendFs {
+			return fmt.Errorf("watching notes requires the %q storage backend", storage.BackendFs)
+		}
+
+		err := watcher.Watch(ctx, config.Cfg.App.NoteDirectory,
+			handlers.IngestExternalEdit(&config.Cfg), handlers.IngestExternalRemoval(&config.Cfg))
+		if err != nil {
+			return fmt.Errorf("failed to watch note directory: %w", err)
+		}
+	}
+
 	srv := newServer(&config.Cfg)
 
 	httpServer := &http.Server{
@@ -127,6 +204,9 @@ func run(ctx context.Context) error {
 		if err := httpServer.Shutdown(shutdownCtx); err != nil {
 			logger.Log.Error().Err(err).Msg("Failed to shutdown server")
 		}
+
+		// Sites still waiting on changes made before the server stopped
+		publish.Flush()
 	}()
 
 	wg.Wait()
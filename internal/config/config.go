@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -18,15 +19,62 @@ type AppConfig struct {
 		LogDirectory    string `mapstructure:"log_directory"`
 		NoteDirectory    string `mapstructure:"note_directory"`
 		Environment     string `mapstructure:"environment"` // "debug" or "prod"
+		WatchNotes      bool   `mapstructure:"watch_notes"` // pick up edits and deletions made directly to files in NoteDirectory
+
+		MaxAttachmentSize int64    `mapstructure:"max_attachment_size"` // bytes
+		AttachmentTypes   []string `mapstructure:"attachment_types"`    // allowed MIME types, as sniffed from the content
+		MaxImportSize     int64    `mapstructure:"max_import_size"`     // bytes, of the archive as well as its extracted contents
+
+		PublicDirectory string `mapstructure:"public_directory"` // where published notes are generated to, as static HTML
 	} `mapstructure:"app"`
+	Storage struct {
+		Backend string `mapstructure:"backend"` // "fs" (default) or "s3"
+		S3      struct {
+			Endpoint  string `mapstructure:"endpoint"` // e.g. "http://localhost:9000"
+			Region    string `mapstructure:"region"`
+			Bucket    string `mapstructure:"bucket"`
+			Prefix    string `mapstructure:"prefix"`
+			AccessKey string `mapstructure:"access_key"`
+			SecretKey string `mapstructure:"secret_key"`
+		} `mapstructure:"s3"`
+	} `mapstructure:"storage"`
+	Git struct {
+		Enabled         bool   `mapstructure:"enabled"`          // version each user's note directory as a git repo
+		RemoteDirectory string `mapstructure:"remote_directory"` // optional; commits are pushed to bare repos at <dir>/<username>.git
+	} `mapstructure:"git"`
 	Server struct {
 		Host string `mapstructure:"host"`
 		Port int    `mapstructure:"port"`
 	} `mapstructure:"server"`
 	Secrets struct {
 		JWT_ACCESS_SECRET  string `mapstructure:"JWT_ACCESS_SECRET"`
-		JWT_REFRESH_SECRET string `mapstructure:"JWT_ACCESS_SECRET"`
+		JWT_REFRESH_SECRET string `mapstructure:"JWT_REFRESH_SECRET"`
 	} `mapstructure:"secrets"`
+	Auth struct {
+		AccessTokenTTL  time.Duration `mapstructure:"access_token_ttl"`  // e.g. "15m"
+		RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl"` // e.g. "720h"
+
+		MinPasswordLength int           `mapstructure:"min_password_length"`
+		PasswordResetTTL  time.Duration `mapstructure:"password_reset_ttl"` // how long admin-issued reset tokens stay usable
+		SignupMode        string        `mapstructure:"signup_mode"`        // "open" (default): anyone can sign up, "invite": only with an invitation, "disabled": only admins create users
+		InvitationTTL     time.Duration `mapstructure:"invitation_ttl"`     // how long invitation codes stay usable
+		InvitationURL     string        `mapstructure:"invitation_url"`     // e.g. "https://notes.example.com/signup"; invitations come with a link to it carrying the code as `invite`
+	} `mapstructure:"auth"`
+	OIDC struct {
+		Enabled      bool     `mapstructure:"enabled"`
+		Issuer       string   `mapstructure:"issuer"` // discovery document is fetched from <issuer>/.well-known/openid-configuration
+		ClientID     string   `mapstructure:"client_id"`
+		ClientSecret string   `mapstructure:"client_secret"` // optional; PKCE is always used
+		RedirectURL  string   `mapstructure:"redirect_url"`  // where `/oidc/callback` is reachable, e.g. "https://notes.example.com/oidc/callback"
+		Scopes       []string `mapstructure:"scopes"`
+
+		UsernameClaim string            `mapstructure:"username_claim"` // defaults to "preferred_username"
+		RoleClaim     string            `mapstructure:"role_claim"`     // e.g. "groups"
+		RoleMapping   map[string]string `mapstructure:"role_mapping"`   // role claim value (lowercased) -> role
+	} `mapstructure:"oidc"`
+	Notify struct {
+		Backend string `mapstructure:"backend"` // "log" (default): messages such as password reset tokens are only logged
+	} `mapstructure:"notify"`
 }
 
 func Initialize() error {
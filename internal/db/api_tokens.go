@@ -0,0 +1,117 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Stores the hash of a new API token, returning its id. `expiresAt` is unix
+// time, or zero for tokens that never expire. Returns ErrConflict if the user
+// already has a token by that name.
+func CreateApiToken(username, name, token string, scopes []string, expiresAt int64) (int64, error) {
+	var expires sql.NullInt64
+	if expiresAt > 0 {
+		expires = sql.NullInt64{Int64: expiresAt, Valid: true}
+	}
+
+	result, err := db.Exec(
+		queries.InsertApiTokenQuery,
+		username, name, HashContent([]byte(token)), strings.Join(scopes, " "), expires,
+	)
+	if isUniqueViolation(err) {
+		return 0, ErrConflict
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create api token: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error getting last inserted id: %w", err)
+	}
+
+	return id, nil
+}
+
+func GetApiTokens(username string) ([]utils.ApiToken, error) {
+	rows, err := db.Query(queries.GetUserApiTokensQuery, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []utils.ApiToken{}
+
+	for rows.Next() {
+		var (
+			t                     utils.ApiToken
+			id, createdAt         int64
+			scopes                string
+			expiresAt, lastUsedAt sql.NullInt64
+		)
+
+		err = rows.Scan(&id, &t.Name, &scopes, &createdAt, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to ApiToken obj: %w", err)
+		}
+
+		t.Id = strconv.FormatInt(id, 10)
+		t.Scopes = strings.Fields(scopes)
+		t.CreatedAt = strconv.FormatInt(createdAt, 10)
+		if expiresAt.Valid {
+			t.ExpiresAt = strconv.FormatInt(expiresAt.Int64, 10)
+		}
+		if lastUsedAt.Valid {
+			t.LastUsedAt = strconv.FormatInt(lastUsedAt.Int64, 10)
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func DeleteApiToken(username string, id int64) error {
+	result, err := db.Exec(queries.DeleteApiTokenQuery, id, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+
+	return expectAffected(result)
+}
+
+// Returns who an API token belongs to, their role and the token's scopes, or
+// ErrNotFound if it's unknown, revoked or expired. Records the token as used.
+func AuthenticateApiToken(token string) (string, string, []string, error) {
+	var (
+		id             int64
+		username, role string
+		scopes         string
+	)
+
+	err := db.QueryRow(queries.GetApiTokenQuery, HashContent([]byte(token))).Scan(&id, &username, &role, &scopes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to look up api token: %w", err)
+	}
+
+	_, err = db.Exec(queries.TouchApiTokenQuery, id)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to record api token use: %w", err)
+	}
+
+	return username, utils.NormalizeRole(role), strings.Fields(scopes), nil
+}
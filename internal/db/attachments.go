@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+func createAttachment(e execer, noteId int64, id, filename, mimeType string, size int64) error {
+	_, err := e.Exec(queries.InsertAttachmentQuery, id, noteId, filename, mimeType, size)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+
+	return nil
+}
+
+func CreateAttachment(owner, notename, id, filename, mimeType string, size int64) error {
+	noteId, err := getNoteId(db, owner, notename)
+	if err != nil {
+		return err
+	}
+
+	return createAttachment(db, noteId, id, filename, mimeType, size)
+}
+
+// Returns the attachment along with the username of its note's owner
+func GetAttachment(id string) (utils.Attachment, string, error) {
+	var (
+		a         utils.Attachment
+		size      int64
+		createdAt int64
+		owner     string
+	)
+
+	err := db.QueryRow(queries.GetAttachmentQuery, id).Scan(
+		&a.Id, &a.NoteName, &a.Filename, &a.MimeType, &size, &createdAt, &owner,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, "", ErrNotFound
+	}
+	if err != nil {
+		return a, "", fmt.Errorf("failed to get attachment: %w", err)
+	}
+
+	a.Size = strconv.FormatInt(size, 10)
+	a.CreatedAt = strconv.FormatInt(createdAt, 10)
+
+	return a, owner, nil
+}
+
+func GetNoteAttachments(owner, notename string) ([]utils.Attachment, error) {
+	noteId, err := getNoteId(db, owner, notename)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(queries.GetNoteAttachmentsQuery, noteId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := []utils.Attachment{}
+
+	for rows.Next() {
+		var (
+			a         utils.Attachment
+			size      int64
+			createdAt int64
+		)
+
+		err = rows.Scan(&a.Id, &a.NoteName, &a.Filename, &a.MimeType, &size, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to Attachment obj: %w", err)
+		}
+
+		a.Size = strconv.FormatInt(size, 10)
+		a.CreatedAt = strconv.FormatInt(createdAt, 10)
+		attachments = append(attachments, a)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return attachments, nil
+}
+
+func DeleteAttachment(id string) error {
+	result, err := db.Exec(queries.DeleteAttachmentQuery, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	return expectAffected(result)
+}
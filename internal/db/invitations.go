@@ -0,0 +1,155 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Creates a single-use code for signing up with `role`, returning it along
+// with the invitation's id and when it expires. Only its hash is stored.
+func CreateInvitation(issuer, role string, ttl time.Duration) (string, int64, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("failed to generate invitation code: %w", err)
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(ttl)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(queries.DeleteExpiredInvitationsQuery)
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("failed to clean up invitations: %w", err)
+	}
+
+	result, err := tx.Exec(queries.InsertInvitationQuery, HashContent([]byte(code)), role, issuer, expiresAt.Unix())
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("error getting last inserted id: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("failed to commit invitation: %w", err)
+	}
+
+	return code, id, expiresAt, nil
+}
+
+func GetInvitations() ([]utils.Invitation, error) {
+	rows, err := db.Query(queries.GetInvitationsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := []utils.Invitation{}
+
+	for rows.Next() {
+		var (
+			inv                  utils.Invitation
+			id                   int64
+			createdBy            sql.NullString
+			createdAt, expiresAt int64
+		)
+
+		err = rows.Scan(&id, &inv.Role, &createdBy, &createdAt, &expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to Invitation obj: %w", err)
+		}
+
+		inv.Id = strconv.FormatInt(id, 10)
+		inv.Role = utils.NormalizeRole(inv.Role)
+		inv.CreatedBy = createdBy.String
+		inv.CreatedAt = strconv.FormatInt(createdAt, 10)
+		inv.ExpiresAt = strconv.FormatInt(expiresAt, 10)
+		invitations = append(invitations, inv)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// Withdraws an invitation that hasn't been used yet
+func DeleteInvitation(id int64) error {
+	result, err := db.Exec(queries.DeleteInvitationQuery, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+
+	return expectAffected(result)
+}
+
+// Creates a user with the role an invitation was made for, using it up.
+// Returns the role, ErrNotFound if the code is unknown, expired or already
+// used, and ErrConflict if the username is taken (the invitation then stays
+// usable).
+func SignupWithInvitation(code, username, password string) (string, error) {
+	hashedPassword, salt, err := hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		id   int64
+		role string
+	)
+
+	err = tx.QueryRow(queries.GetInvitationQuery, HashContent([]byte(code))).Scan(&id, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up invitation: %w", err)
+	}
+
+	_, err = tx.Exec(queries.InsertUserQuery, username, role, hashedPassword, salt)
+	if isUniqueViolation(err) {
+		return "", ErrConflict
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	// Racing signups with the same code: only one gets to delete it
+	result, err := tx.Exec(queries.DeleteInvitationQuery, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to use up invitation: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit signup: %w", err)
+	}
+
+	return utils.NormalizeRole(role), nil
+}
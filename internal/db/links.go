@@ -0,0 +1,153 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Replaces the links going out of a note with `links`
+func SetNoteLinks(owner, notename string, links []utils.NoteLink) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	noteId, err := getNoteId(tx, owner, notename)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queries.DeleteNoteLinksQuery, noteId)
+	if err != nil {
+		return fmt.Errorf("failed to clear note links: %w", err)
+	}
+
+	for _, l := range links {
+		_, err = tx.Exec(queries.InsertNoteLinkQuery, noteId, l.Target, l.Kind)
+		if err != nil {
+			return fmt.Errorf("failed to insert note link: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit note links: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the names of the notes linking to `notename`, which needn't exist
+func GetBacklinks(owner, notename string) ([]string, error) {
+	rows, err := db.Query(queries.GetBacklinksQuery, owner, notename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backlinks: %w", err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan backlink: %w", err)
+		}
+
+		names = append(names, name)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return names, nil
+}
+
+func GetBrokenLinks(owner string) ([]utils.BrokenLink, error) {
+	rows, err := db.Query(queries.GetBrokenLinksQuery, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broken links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []utils.BrokenLink{}
+
+	for rows.Next() {
+		var l utils.BrokenLink
+		if err = rows.Scan(&l.Source, &l.Target); err != nil {
+			return nil, fmt.Errorf("failed to scan broken link: %w", err)
+		}
+
+		links = append(links, l)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return links, nil
+}
+
+// Every note of a user, and an edge for each pair of notes where one links to
+// the other. Broken links are left out.
+func GetLinkGraph(owner string) (utils.LinkGraph, error) {
+	graph := utils.LinkGraph{
+		Nodes: []utils.LinkGraphNode{},
+		Edges: []utils.LinkGraphEdge{},
+	}
+
+	rows, err := db.Query(queries.GetUsersNoteNamesQuery, owner)
+	if err != nil {
+		return graph, fmt.Errorf("failed to get notes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+
+		if err = rows.Scan(&id, &name); err != nil {
+			return graph, fmt.Errorf("failed to scan note: %w", err)
+		}
+
+		graph.Nodes = append(graph.Nodes, utils.LinkGraphNode{
+			Id:   strconv.FormatInt(id, 10),
+			Name: name,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return graph, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	rows, err = db.Query(queries.GetUsersLinksQuery, owner)
+	if err != nil {
+		return graph, fmt.Errorf("failed to get links: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var source, target int64
+		if err = rows.Scan(&source, &target); err != nil {
+			return graph, fmt.Errorf("failed to scan link: %w", err)
+		}
+
+		graph.Edges = append(graph.Edges, utils.LinkGraphEdge{
+			Source: strconv.FormatInt(source, 10),
+			Target: strconv.FormatInt(target, 10),
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return graph, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return graph, nil
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+func RecordFailedLogin(username, ip, reason string) error {
+	_, err := db.Exec(queries.InsertLoginAttemptQuery, username, ip, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	return nil
+}
+
+// The latest `limit` failed logins, for `username` or (when empty) anyone
+func GetFailedLogins(username string, limit int) ([]utils.LoginAttempt, error) {
+	rows, err := db.Query(queries.GetLoginAttemptsQuery, username, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []utils.LoginAttempt{}
+
+	for rows.Next() {
+		var (
+			a               utils.LoginAttempt
+			id, attemptedAt int64
+		)
+
+		err = rows.Scan(&id, &a.Username, &a.Ip, &a.Reason, &attemptedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to LoginAttempt obj: %w", err)
+		}
+
+		a.Id = strconv.FormatInt(id, 10)
+		a.AttemptedAt = strconv.FormatInt(attemptedAt, 10)
+		attempts = append(attempts, a)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return attempts, nil
+}
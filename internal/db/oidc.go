@@ -0,0 +1,76 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Finds the user an identity provider's subject maps to, returning their name
+// and current role, and creates them on their first login with `role` (or
+// `defaultRole` when it's empty). Existing users' roles are left to
+// SetUserRole. Returns ErrConflict if `username` belongs to someone else, and
+// ErrUserDisabled if the user they map to has been disabled.
+func ProvisionOidcUser(issuer, subject, username, role, defaultRole string) (string, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		existingUsername, existingRole string
+		disabled                       bool
+	)
+
+	err = tx.QueryRow(queries.GetOidcUserQuery, issuer, subject).Scan(&existingUsername, &existingRole, &disabled)
+	switch {
+	case err == nil && disabled:
+		return "", "", ErrUserDisabled
+
+	case err == nil:
+		return existingUsername, utils.NormalizeRole(existingRole), nil
+
+	case !errors.Is(err, sql.ErrNoRows):
+		return "", "", fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	// The provider's usernames become storage paths like any other
+	if err = utils.ValidateUsername(username); err != nil {
+		return "", "", err
+	}
+
+	if role == "" {
+		role = defaultRole
+	}
+
+	// Nobody knows this password, so the account can only be logged into
+	// through the provider (or after an admin-issued reset)
+	hashedPassword, salt, err := hashPassword(rand.Text())
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = tx.Exec(queries.InsertUserQuery, username, role, hashedPassword, salt)
+	if isUniqueViolation(err) {
+		return "", "", ErrConflict
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	_, err = tx.Exec(queries.InsertOidcIdentityQuery, issuer, subject, username)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", "", fmt.Errorf("failed to commit user provisioning: %w", err)
+	}
+
+	return username, utils.NormalizeRole(role), nil
+}
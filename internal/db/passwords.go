@@ -0,0 +1,159 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Returns ErrUserDisabled for disabled users, who mustn't be issued tokens
+func GetUserRole(username string) (string, error) {
+	var (
+		role     string
+		disabled bool
+	)
+
+	err := db.QueryRow(queries.GetUserRoleQuery, username).Scan(&role, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get user role: %w", err)
+	}
+
+	if disabled {
+		return "", ErrUserDisabled
+	}
+
+	return utils.NormalizeRole(role), nil
+}
+
+func setPassword(e execer, username, password string) error {
+	hashedPassword, salt, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	result, err := e.Exec(queries.UpdatePasswordQuery, hashedPassword, salt, username)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return err
+	}
+
+	_, err = e.Exec(queries.DeleteUserPasswordResetTokensQuery, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete password reset tokens: %w", err)
+	}
+
+	return nil
+}
+
+// Replaces a user's password, invalidating any reset tokens they were issued
+func SetPassword(username, password string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = setPassword(tx, username, password); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit password change: %w", err)
+	}
+
+	return nil
+}
+
+// Creates a one-time token with which `username` can set a new password
+// without knowing the old one. Only its hash is stored.
+func CreatePasswordResetToken(username, issuer string, ttl time.Duration) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(ttl)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(queries.DeleteExpiredPasswordResetTokensQuery)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to clean up password reset tokens: %w", err)
+	}
+
+	result, err := tx.Exec(queries.InsertPasswordResetTokenQuery, HashContent([]byte(token)), issuer, expiresAt.Unix(), username)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create password reset token: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to commit password reset token: %w", err)
+	}
+
+	return token, expiresAt, nil
+}
+
+// Returns who a reset token was issued to, or ErrNotFound if it's unknown,
+// expired or already used
+func GetPasswordResetUser(token string) (string, error) {
+	return getPasswordResetUser(db, token)
+}
+
+func getPasswordResetUser(q queryRower, token string) (string, error) {
+	var username string
+
+	err := q.QueryRow(queries.GetPasswordResetUserQuery, HashContent([]byte(token))).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up password reset token: %w", err)
+	}
+
+	return username, nil
+}
+
+// Uses up a reset token to set its user's password, returning their username
+func ResetPassword(token, password string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	username, err := getPasswordResetUser(tx, token)
+	if err != nil {
+		return "", err
+	}
+
+	if err = setPassword(tx, username, password); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit password reset: %w", err)
+	}
+
+	return username, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+)
+
+// Replaces a note's properties; values are either strings or []string
+func SetNoteProperties(owner, notename string, properties map[string]any) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	noteId, err := getNoteId(tx, owner, notename)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queries.DeleteNotePropertiesQuery, noteId)
+	if err != nil {
+		return fmt.Errorf("failed to clear note properties: %w", err)
+	}
+
+	for key, value := range properties {
+		switch value := value.(type) {
+		case string:
+			_, err = tx.Exec(queries.InsertNotePropertyQuery, noteId, key, value, nil)
+		case []string:
+			for i, item := range value {
+				_, err = tx.Exec(queries.InsertNotePropertyQuery, noteId, key, item, i)
+				if err != nil {
+					break
+				}
+			}
+		default:
+			err = fmt.Errorf("unsupported type %T for property %q", value, key)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to insert note property: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit note properties: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the properties of every note of a user, by note id
+func getUserNoteProperties(username string) (map[int64]map[string]any, error) {
+	rows, err := db.Query(queries.GetUsersNotePropertiesQuery, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get note properties: %w", err)
+	}
+	defer rows.Close()
+
+	properties := map[int64]map[string]any{}
+
+	for rows.Next() {
+		var (
+			noteId   int64
+			key      string
+			value    string
+			position sql.NullInt64
+		)
+
+		err = rows.Scan(&noteId, &key, &value, &position)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan note property: %w", err)
+		}
+
+		if properties[noteId] == nil {
+			properties[noteId] = map[string]any{}
+		}
+
+		if !position.Valid {
+			properties[noteId][key] = value
+			continue
+		}
+
+		list, _ := properties[noteId][key].([]string)
+		properties[noteId][key] = append(list, value)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return properties, nil
+}
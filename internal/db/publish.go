@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+)
+
+func AddPublishedPaths(owner string, paths []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range paths {
+		_, err = tx.Exec(queries.InsertPublishedPathQuery, owner, p)
+		if err != nil {
+			return fmt.Errorf("failed to publish path: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit published paths: %w", err)
+	}
+
+	return nil
+}
+
+func RemovePublishedPaths(owner string, paths []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range paths {
+		_, err = tx.Exec(queries.DeletePublishedPathQuery, owner, p)
+		if err != nil {
+			return fmt.Errorf("failed to unpublish path: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit published paths: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the note names and folders (ending with a slash) a user publishes
+func GetPublishedPaths(owner string) ([]string, error) {
+	rows, err := db.Query(queries.GetPublishedPathsQuery, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get published paths: %w", err)
+	}
+	defer rows.Close()
+
+	paths := []string{}
+
+	for rows.Next() {
+		var p string
+		if err = rows.Scan(&p); err != nil {
+			return nil, fmt.Errorf("failed to scan published path: %w", err)
+		}
+
+		paths = append(paths, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return paths, nil
+}
@@ -23,11 +23,219 @@ CREATE TABLE IF NOT EXISTS Notes (
 );
 
 CREATE INDEX IF NOT EXISTS idx_notes_user_id ON Notes (user_id);
+
+-- Content-addressed note contents; shared by every version with the same hash
+CREATE TABLE IF NOT EXISTS NoteBlobs (
+    hash CHAR(64) PRIMARY KEY,
+    content BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS NoteVersions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    note_id INTEGER NOT NULL,
+    author_id INTEGER,
+    blob_hash CHAR(64) NOT NULL,
+    created_at INTEGER DEFAULT (unixepoch()),
+    FOREIGN KEY (note_id) REFERENCES Notes(id) ON DELETE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES Users(id) ON DELETE SET NULL,
+    FOREIGN KEY (blob_hash) REFERENCES NoteBlobs(hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_versions_note_id ON NoteVersions (note_id);
+
+CREATE TABLE IF NOT EXISTS Attachments (
+    id CHAR(36) PRIMARY KEY, -- uuid, also the attachment's name in storage
+    note_id INTEGER NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(255) NOT NULL,
+    size INTEGER NOT NULL,
+    created_at INTEGER DEFAULT (unixepoch()),
+    FOREIGN KEY (note_id) REFERENCES Notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_note_id ON Attachments (note_id);
+
+-- Parsed from the note's front matter; list values take one row per item
+CREATE TABLE IF NOT EXISTS NoteProperties (
+    note_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+    position INTEGER, -- NULL for scalar values, index into the list otherwise
+    FOREIGN KEY (note_id) REFERENCES Notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_properties_note_id ON NoteProperties (note_id);
+CREATE INDEX IF NOT EXISTS idx_note_properties_key_value ON NoteProperties (key, value);
+
+CREATE TABLE IF NOT EXISTS Tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS NoteTags (
+    note_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (note_id, tag_id),
+    FOREIGN KEY (note_id) REFERENCES Notes(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES Tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON NoteTags (tag_id);
+
+-- links point at note names rather than ids, so they can dangle
+CREATE TABLE IF NOT EXISTS NoteLinks (
+    source_note_id INTEGER NOT NULL,
+    target_name VARCHAR(255) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    PRIMARY KEY (source_note_id, target_name, kind),
+    FOREIGN KEY (source_note_id) REFERENCES Notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_links_target_name ON NoteLinks (target_name);
+
+-- a note name, or a folder (ending with a slash) to publish every note under
+CREATE TABLE IF NOT EXISTS PublishedPaths (
+    user_id INTEGER NOT NULL,
+    path VARCHAR(255) NOT NULL,
+    created_at INTEGER DEFAULT (unixepoch()),
+    PRIMARY KEY (user_id, path),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- notes that new notes can be created from; shared ones are visible to every user
+CREATE TABLE IF NOT EXISTS Templates (
+    note_id INTEGER PRIMARY KEY,
+    shared INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER DEFAULT (unixepoch()),
+    FOREIGN KEY (note_id) REFERENCES Notes(id) ON DELETE CASCADE
+);
+
+-- every login starts a family of refresh tokens, each one replacing the last
+CREATE TABLE IF NOT EXISTS RefreshTokenFamilies (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at_ms INTEGER NOT NULL, -- unix time in milliseconds, to compare with token cutoffs
+    revoked_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS RefreshTokens (
+    id VARCHAR(36) PRIMARY KEY, -- the token's jti
+    family_id VARCHAR(36) NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER,
+    created_at INTEGER DEFAULT (unixepoch()),
+    FOREIGN KEY (family_id) REFERENCES RefreshTokenFamilies(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON RefreshTokens (family_id);
+
+-- access tokens logged out before they expired
+CREATE TABLE IF NOT EXISTS RevokedTokens (
+    jti VARCHAR(36) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- tokens issued to a user at or before cutoff_ms (unix time in milliseconds) are no longer accepted
+CREATE TABLE IF NOT EXISTS TokenCutoffs (
+    user_id INTEGER PRIMARY KEY,
+    cutoff_ms INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- one-time tokens for setting a new password, handed out by admins
+CREATE TABLE IF NOT EXISTS PasswordResetTokens (
+    token_hash CHAR(64) PRIMARY KEY, -- sha256 of the token
+    user_id INTEGER NOT NULL,
+    issued_by INTEGER,
+    created_at INTEGER DEFAULT (unixepoch()),
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    FOREIGN KEY (issued_by) REFERENCES Users(id) ON DELETE SET NULL
+);
+
+-- TOTP second factor; only asked for at login once enabled_at is set
+CREATE TABLE IF NOT EXISTS UserTotp (
+    user_id INTEGER PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL, -- base32
+    enabled_at INTEGER,
+    last_step INTEGER, -- time step of the last accepted code, which can't be used again
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL, -- sha256 of the normalized code
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- users who log in through an OpenID Connect provider, by the provider's id for them
+CREATE TABLE IF NOT EXISTS OidcIdentities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- long-lived tokens for scripts, limited to some routes by their scopes
+CREATE TABLE IF NOT EXISTS ApiTokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL, -- sha256 of the token
+    scopes TEXT NOT NULL, -- space separated
+    created_at INTEGER DEFAULT (unixepoch()),
+    expires_at INTEGER,
+    last_used_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    UNIQUE (user_id, name)
+);
+
+-- audit trail of failed logins; usernames are kept as given, since they may not exist
+CREATE TABLE IF NOT EXISTS LoginAttempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    reason VARCHAR(64) NOT NULL,
+    attempted_at INTEGER DEFAULT (unixepoch())
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON LoginAttempts (username);
+
+-- users who can't log in or use their tokens; their notes are left alone
+CREATE TABLE IF NOT EXISTS DisabledUsers (
+    user_id INTEGER PRIMARY KEY,
+    disabled_by INTEGER,
+    disabled_at INTEGER DEFAULT (unixepoch()),
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    FOREIGN KEY (disabled_by) REFERENCES Users(id) ON DELETE SET NULL
+);
+
+-- single-use codes admins hand out for signing up, deleted once used
+CREATE TABLE IF NOT EXISTS Invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code_hash CHAR(64) UNIQUE NOT NULL, -- sha256 of the code
+    role VARCHAR(255) NOT NULL, -- given to whoever signs up with it
+    created_by INTEGER,
+    created_at INTEGER DEFAULT (unixepoch()),
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (created_by) REFERENCES Users(id) ON DELETE SET NULL
+);
 `
 
 const InsertUserQuery = `INSERT INTO Users (username, role, pw_hash, salt) VALUES (?, ?, ?, ?)`
 
-const GetUserQuery = `SELECT role, pw_hash, salt FROM Users WHERE username = ?`
+const GetUserQuery = `
+SELECT u.role, u.pw_hash, u.salt, EXISTS (SELECT 1 FROM DisabledUsers d WHERE d.user_id = u.id)
+FROM Users u WHERE u.username = ?
+`
 
 const InsertNoteQuery = `
 INSERT INTO Notes (user_id, name) VALUES ((SELECT id FROM Users WHERE username = ?), ?)
@@ -38,9 +246,437 @@ const DeleteNoteQuery = `
 DELETE FROM Notes WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND name = ?
 `
 
+const RenameNoteQuery = `
+UPDATE Notes SET name = ?, last_modified = unixepoch() WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND name = ?
+`
+
 const GetUsersNotesMetadata = `
-SELECT n.id, n.name, n.created_at, n.last_modified from Notes n JOIN Users u on u.id = n.user_id
+SELECT n.id, n.name, n.created_at, n.last_modified from Notes n JOIN Users u on u.id = n.user_id WHERE u.username = ?
 `
 
+const GetUsernamesQuery = `SELECT username FROM Users ORDER BY username`
+
 const UpdateNoteModificationTime = `
+UPDATE Notes SET last_modified = unixepoch() WHERE id = ?
+`
+
+const GetNoteIdQuery = `
+SELECT n.id FROM Notes n JOIN Users u ON u.id = n.user_id WHERE u.username = ? AND n.name = ?
+`
+
+const InsertNoteBlobQuery = `INSERT OR IGNORE INTO NoteBlobs (hash, content) VALUES (?, ?)`
+
+const GetLatestNoteVersionHashQuery = `
+SELECT blob_hash FROM NoteVersions WHERE note_id = ? ORDER BY id DESC LIMIT 1
+`
+
+const InsertNoteVersionQuery = `
+INSERT INTO NoteVersions (note_id, author_id, blob_hash) VALUES (?, (SELECT id FROM Users WHERE username = ?), ?)
+`
+
+const GetNoteVersionsQuery = `
+SELECT v.id, COALESCE(u.username, ''), v.created_at, v.blob_hash, length(b.content)
+FROM NoteVersions v
+JOIN NoteBlobs b ON b.hash = v.blob_hash
+LEFT JOIN Users u ON u.id = v.author_id
+WHERE v.note_id = ?
+ORDER BY v.id DESC
+`
+
+const GetNoteVersionContentQuery = `
+SELECT b.content FROM NoteVersions v JOIN NoteBlobs b ON b.hash = v.blob_hash WHERE v.note_id = ? AND v.id = ?
 `
+
+// blobs are shared across notes, so only drop those no version points at anymore
+const DeleteUnreferencedBlobsQuery = `
+DELETE FROM NoteBlobs WHERE hash NOT IN (SELECT blob_hash FROM NoteVersions)
+`
+
+const InsertAttachmentQuery = `
+INSERT INTO Attachments (id, note_id, filename, mime_type, size) VALUES (?, ?, ?, ?, ?)
+`
+
+const GetAttachmentQuery = `
+SELECT a.id, n.name, a.filename, a.mime_type, a.size, a.created_at, u.username
+FROM Attachments a
+JOIN Notes n ON n.id = a.note_id
+JOIN Users u ON u.id = n.user_id
+WHERE a.id = ?
+`
+
+const GetNoteAttachmentsQuery = `
+SELECT a.id, n.name, a.filename, a.mime_type, a.size, a.created_at
+FROM Attachments a
+JOIN Notes n ON n.id = a.note_id
+WHERE a.note_id = ?
+ORDER BY a.created_at, a.id
+`
+
+const DeleteAttachmentQuery = `DELETE FROM Attachments WHERE id = ?`
+
+const DeleteNotePropertiesQuery = `DELETE FROM NoteProperties WHERE note_id = ?`
+
+const InsertNotePropertyQuery = `
+INSERT INTO NoteProperties (note_id, key, value, position) VALUES (?, ?, ?, ?)
+`
+
+const GetUsersNotePropertiesQuery = `
+SELECT p.note_id, p.key, p.value, p.position
+FROM NoteProperties p
+JOIN Notes n ON n.id = p.note_id
+JOIN Users u ON u.id = n.user_id
+WHERE u.username = ?
+ORDER BY p.note_id, p.key, p.position
+`
+
+const InsertTagQuery = `
+INSERT OR IGNORE INTO Tags (user_id, name) VALUES ((SELECT id FROM Users WHERE username = ?), ?)
+`
+
+const InsertNoteTagQuery = `
+INSERT OR IGNORE INTO NoteTags (note_id, tag_id)
+VALUES (?, (SELECT t.id FROM Tags t JOIN Users u ON u.id = t.user_id WHERE u.username = ? AND t.name = ?))
+`
+
+const DeleteNoteTagQuery = `
+DELETE FROM NoteTags
+WHERE note_id = ? AND tag_id = (SELECT t.id FROM Tags t JOIN Users u ON u.id = t.user_id WHERE u.username = ? AND t.name = ?)
+`
+
+const DeleteUnusedTagsQuery = `
+DELETE FROM Tags WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND id NOT IN (SELECT tag_id FROM NoteTags)
+`
+
+// tags that aren't on any note (anymore) aren't listed
+const GetUserTagsQuery = `
+SELECT t.name, COUNT(nt.note_id)
+FROM Tags t
+JOIN Users u ON u.id = t.user_id
+JOIN NoteTags nt ON nt.tag_id = t.id
+WHERE u.username = ?
+GROUP BY t.id
+ORDER BY t.name
+`
+
+const GetUsersNoteTagsQuery = `
+SELECT nt.note_id, t.name
+FROM NoteTags nt
+JOIN Tags t ON t.id = nt.tag_id
+JOIN Users u ON u.id = t.user_id
+WHERE u.username = ?
+ORDER BY nt.note_id, t.name
+`
+
+const DeleteNoteLinksQuery = `DELETE FROM NoteLinks WHERE source_note_id = ?`
+
+const InsertNoteLinkQuery = `
+INSERT OR IGNORE INTO NoteLinks (source_note_id, target_name, kind) VALUES (?, ?, ?)
+`
+
+const GetBacklinksQuery = `
+SELECT DISTINCT n.name
+FROM NoteLinks l
+JOIN Notes n ON n.id = l.source_note_id
+JOIN Users u ON u.id = n.user_id
+WHERE u.username = ? AND l.target_name = ?
+ORDER BY n.name
+`
+
+// links whose target isn't one of the user's notes
+const GetBrokenLinksQuery = `
+SELECT DISTINCT n.name, l.target_name
+FROM NoteLinks l
+JOIN Notes n ON n.id = l.source_note_id
+JOIN Users u ON u.id = n.user_id
+WHERE u.username = ? AND NOT EXISTS (SELECT 1 FROM Notes t WHERE t.user_id = u.id AND t.name = l.target_name)
+ORDER BY n.name, l.target_name
+`
+
+const GetUsersLinksQuery = `
+SELECT DISTINCT n.id, t.id
+FROM NoteLinks l
+JOIN Notes n ON n.id = l.source_note_id
+JOIN Users u ON u.id = n.user_id
+JOIN Notes t ON t.user_id = u.id AND t.name = l.target_name
+WHERE u.username = ?
+ORDER BY n.id, t.id
+`
+
+const GetUsersNoteNamesQuery = `
+SELECT n.id, n.name FROM Notes n JOIN Users u ON u.id = n.user_id WHERE u.username = ? ORDER BY n.name
+`
+
+const InsertPublishedPathQuery = `
+INSERT OR IGNORE INTO PublishedPaths (user_id, path) VALUES ((SELECT id FROM Users WHERE username = ?), ?)
+`
+
+// A note published by name stays published under its new one
+const RenamePublishedPathQuery = `
+UPDATE OR REPLACE PublishedPaths SET path = ? WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND path = ?
+`
+
+const DeletePublishedPathQuery = `
+DELETE FROM PublishedPaths WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND path = ?
+`
+
+const GetPublishedPathsQuery = `
+SELECT p.path FROM PublishedPaths p JOIN Users u ON u.id = p.user_id WHERE u.username = ? ORDER BY p.path
+`
+
+const UpsertTemplateQuery = `
+INSERT INTO Templates (note_id, shared) VALUES (?, ?) ON CONFLICT (note_id) DO UPDATE SET shared = excluded.shared
+`
+
+const DeleteTemplateQuery = `DELETE FROM Templates WHERE note_id = ?`
+
+// a user's own templates, and those shared by anyone
+const GetTemplatesQuery = `
+SELECT t.note_id, n.name, u.username, t.shared, t.created_at
+FROM Templates t
+JOIN Notes n ON n.id = t.note_id
+JOIN Users u ON u.id = n.user_id
+WHERE u.username = ? OR t.shared = 1
+ORDER BY n.name, t.note_id
+`
+
+const GetTemplateQuery = `
+SELECT n.name, u.username
+FROM Templates t
+JOIN Notes n ON n.id = t.note_id
+JOIN Users u ON u.id = n.user_id
+WHERE t.note_id = ? AND (u.username = ? OR t.shared = 1)
+`
+
+const InsertRefreshTokenFamilyQuery = `
+INSERT INTO RefreshTokenFamilies (id, user_id, created_at_ms) VALUES (?, (SELECT id FROM Users WHERE username = ?), ?)
+`
+
+const InsertRefreshTokenQuery = `INSERT INTO RefreshTokens (id, family_id, expires_at) VALUES (?, ?, ?)`
+
+const GetRefreshTokenQuery = `
+SELECT t.used_at, f.revoked_at
+FROM RefreshTokens t
+JOIN RefreshTokenFamilies f ON f.id = t.family_id
+JOIN Users u ON u.id = f.user_id
+WHERE t.id = ? AND t.family_id = ? AND u.username = ?
+`
+
+const MarkRefreshTokenUsedQuery = `UPDATE RefreshTokens SET used_at = unixepoch() WHERE id = ? AND used_at IS NULL`
+
+const RevokeRefreshTokenFamilyQuery = `
+UPDATE RefreshTokenFamilies SET revoked_at = unixepoch() WHERE id = ? AND revoked_at IS NULL
+`
+
+// expired tokens can't be used (or reused) anymore, so there's no need to keep them
+const DeleteExpiredRefreshTokensQuery = `DELETE FROM RefreshTokens WHERE expires_at < unixepoch()`
+
+const DeleteEmptyRefreshTokenFamiliesQuery = `
+DELETE FROM RefreshTokenFamilies WHERE id NOT IN (SELECT family_id FROM RefreshTokens)
+`
+
+const RevokeUserRefreshTokenFamiliesQuery = `
+UPDATE RefreshTokenFamilies SET revoked_at = unixepoch()
+WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND revoked_at IS NULL AND created_at_ms <= ?
+`
+
+const RevokeUserRefreshTokenFamilyQuery = `
+UPDATE RefreshTokenFamilies SET revoked_at = unixepoch()
+WHERE id = ? AND user_id = (SELECT id FROM Users WHERE username = ?) AND revoked_at IS NULL
+`
+
+const InsertRevokedTokenQuery = `
+INSERT OR IGNORE INTO RevokedTokens (jti, user_id, expires_at) VALUES (?, (SELECT id FROM Users WHERE username = ?), ?)
+`
+
+// revoked tokens that have expired would be turned down anyway
+const DeleteExpiredRevokedTokensQuery = `DELETE FROM RevokedTokens WHERE expires_at < unixepoch()`
+
+const UpsertTokenCutoffQuery = `
+INSERT INTO TokenCutoffs (user_id, cutoff_ms) VALUES ((SELECT id FROM Users WHERE username = ?), ?)
+ON CONFLICT (user_id) DO UPDATE SET cutoff_ms = max(cutoff_ms, excluded.cutoff_ms)
+`
+
+// Tokens of deleted users are turned down too
+const IsTokenRevokedQuery = `
+SELECT EXISTS (SELECT 1 FROM RevokedTokens WHERE jti = ?)
+    OR EXISTS (SELECT 1 FROM TokenCutoffs c JOIN Users u ON u.id = c.user_id WHERE u.username = ? AND c.cutoff_ms >= ?)
+    OR NOT EXISTS (SELECT 1 FROM Users WHERE username = ?)
+`
+
+const GetUserRoleQuery = `
+SELECT u.role, EXISTS (SELECT 1 FROM DisabledUsers d WHERE d.user_id = u.id) FROM Users u WHERE u.username = ?
+`
+
+const UpdatePasswordQuery = `UPDATE Users SET pw_hash = ?, salt = ? WHERE username = ?`
+
+const InsertPasswordResetTokenQuery = `
+INSERT INTO PasswordResetTokens (token_hash, user_id, issued_by, expires_at)
+SELECT ?, u.id, (SELECT id FROM Users WHERE username = ?), ? FROM Users u WHERE u.username = ?
+`
+
+const DeleteExpiredPasswordResetTokensQuery = `DELETE FROM PasswordResetTokens WHERE expires_at < unixepoch()`
+
+const GetPasswordResetUserQuery = `
+SELECT u.username FROM PasswordResetTokens p JOIN Users u ON u.id = p.user_id
+WHERE p.token_hash = ? AND p.expires_at >= unixepoch()
+`
+
+// Once a password is set, none of the user's outstanding reset tokens should work
+const DeleteUserPasswordResetTokensQuery = `
+DELETE FROM PasswordResetTokens WHERE user_id = (SELECT id FROM Users WHERE username = ?)
+`
+
+// Enrolling again before confirming replaces the pending secret
+const UpsertPendingTotpQuery = `
+INSERT INTO UserTotp (user_id, secret) VALUES ((SELECT id FROM Users WHERE username = ?), ?)
+ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_step = NULL WHERE enabled_at IS NULL
+`
+
+const GetTotpQuery = `
+SELECT t.secret, t.enabled_at IS NOT NULL FROM UserTotp t JOIN Users u ON u.id = t.user_id WHERE u.username = ?
+`
+
+const EnableTotpQuery = `
+UPDATE UserTotp SET enabled_at = unixepoch()
+WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND enabled_at IS NULL
+`
+
+const UseTotpStepQuery = `
+UPDATE UserTotp SET last_step = ?
+WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND (last_step IS NULL OR last_step < ?)
+`
+
+const DeleteTotpQuery = `DELETE FROM UserTotp WHERE user_id = (SELECT id FROM Users WHERE username = ?)`
+
+const InsertRecoveryCodeQuery = `
+INSERT INTO RecoveryCodes (user_id, code_hash) VALUES ((SELECT id FROM Users WHERE username = ?), ?)
+`
+
+const DeleteRecoveryCodeQuery = `
+DELETE FROM RecoveryCodes WHERE user_id = (SELECT id FROM Users WHERE username = ?) AND code_hash = ?
+`
+
+const DeleteRecoveryCodesQuery = `DELETE FROM RecoveryCodes WHERE user_id = (SELECT id FROM Users WHERE username = ?)`
+
+const GetOidcUserQuery = `
+SELECT u.username, u.role, EXISTS (SELECT 1 FROM DisabledUsers d WHERE d.user_id = u.id)
+FROM OidcIdentities i JOIN Users u ON u.id = i.user_id WHERE i.issuer = ? AND i.subject = ?
+`
+
+const InsertOidcIdentityQuery = `
+INSERT INTO OidcIdentities (issuer, subject, user_id) VALUES (?, ?, (SELECT id FROM Users WHERE username = ?))
+`
+
+const UpdateUserRoleQuery = `UPDATE Users SET role = ? WHERE username = ?`
+
+const InsertApiTokenQuery = `
+INSERT INTO ApiTokens (user_id, name, token_hash, scopes, expires_at)
+VALUES ((SELECT id FROM Users WHERE username = ?), ?, ?, ?, ?)
+`
+
+const GetUserApiTokensQuery = `
+SELECT t.id, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at
+FROM ApiTokens t JOIN Users u ON u.id = t.user_id
+WHERE u.username = ?
+ORDER BY t.created_at DESC, t.id DESC
+`
+
+const DeleteApiTokenQuery = `
+DELETE FROM ApiTokens WHERE id = ? AND user_id = (SELECT id FROM Users WHERE username = ?)
+`
+
+const GetApiTokenQuery = `
+SELECT t.id, u.username, u.role, t.scopes FROM ApiTokens t JOIN Users u ON u.id = t.user_id
+WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > unixepoch())
+    AND NOT EXISTS (SELECT 1 FROM DisabledUsers d WHERE d.user_id = u.id)
+`
+
+const TouchApiTokenQuery = `UPDATE ApiTokens SET last_used_at = unixepoch() WHERE id = ?`
+
+const InsertLoginAttemptQuery = `INSERT INTO LoginAttempts (username, ip, reason) VALUES (?, ?, ?)`
+
+// Newest first; an empty username matches every attempt
+const GetLoginAttemptsQuery = `
+SELECT id, username, ip, reason, attempted_at FROM LoginAttempts
+WHERE ? = '' OR username = ?
+ORDER BY attempted_at DESC, id DESC
+LIMIT ?
+`
+
+const GetUserIdQuery = `SELECT id FROM Users WHERE username = ?`
+
+const GetUsersQuery = `
+SELECT u.username, u.role, d.disabled_at, (SELECT count(*) FROM Notes n WHERE n.user_id = u.id)
+FROM Users u LEFT JOIN DisabledUsers d ON d.user_id = u.id
+ORDER BY u.username
+`
+
+// Whether the user is the only admin who isn't disabled
+const IsLastAdminQuery = `
+SELECT EXISTS (
+    SELECT 1 FROM Users u WHERE u.username = ? AND u.role = ?
+        AND NOT EXISTS (SELECT 1 FROM DisabledUsers d WHERE d.user_id = u.id)
+) AND NOT EXISTS (
+    SELECT 1 FROM Users u WHERE u.username != ? AND u.role = ?
+        AND NOT EXISTS (SELECT 1 FROM DisabledUsers d WHERE d.user_id = u.id)
+)
+`
+
+const InsertDisabledUserQuery = `
+INSERT OR IGNORE INTO DisabledUsers (user_id, disabled_by) VALUES (?, (SELECT id FROM Users WHERE username = ?))
+`
+
+const DeleteDisabledUserQuery = `DELETE FROM DisabledUsers WHERE user_id = ?`
+
+const GetUserAttachmentsQuery = `
+SELECT a.id, n.name, a.filename, a.mime_type, a.size, a.created_at
+FROM Attachments a
+JOIN Notes n ON n.id = a.note_id
+JOIN Users u ON u.id = n.user_id
+WHERE u.username = ?
+ORDER BY n.name, a.created_at, a.id
+`
+
+// Whether any of the source user's notes would collide with one of the
+// target's once prefixed
+const HasTransferConflictQuery = `
+SELECT EXISTS (
+    SELECT 1 FROM Notes s JOIN Notes t ON t.user_id = ? AND t.name = ? || s.name WHERE s.user_id = ?
+)
+`
+
+const CopyTagsQuery = `INSERT OR IGNORE INTO Tags (user_id, name) SELECT ?, name FROM Tags WHERE user_id = ?`
+
+// Points the source user's note tags at the target's tags of the same name
+const RemapNoteTagsQuery = `
+UPDATE NoteTags SET tag_id = (
+    SELECT t.id FROM Tags t JOIN Tags s ON s.name = t.name WHERE s.id = NoteTags.tag_id AND t.user_id = ?
+)
+WHERE tag_id IN (SELECT id FROM Tags WHERE user_id = ?)
+`
+
+const TransferNotesQuery = `
+UPDATE Notes SET user_id = ?, name = ? || name, last_modified = unixepoch() WHERE user_id = ?
+`
+
+const DeleteUserNotesQuery = `DELETE FROM Notes WHERE user_id = ?`
+
+const DeleteUserQuery = `DELETE FROM Users WHERE id = ?`
+
+const InsertInvitationQuery = `
+INSERT INTO Invitations (code_hash, role, created_by, expires_at) VALUES (?, ?, (SELECT id FROM Users WHERE username = ?), ?)
+`
+
+const DeleteExpiredInvitationsQuery = `DELETE FROM Invitations WHERE expires_at < unixepoch()`
+
+// Outstanding invitations, newest first
+const GetInvitationsQuery = `
+SELECT i.id, i.role, u.username, i.created_at, i.expires_at
+FROM Invitations i LEFT JOIN Users u ON u.id = i.created_by
+WHERE i.expires_at >= unixepoch()
+ORDER BY i.created_at DESC, i.id DESC
+`
+
+const GetInvitationQuery = `SELECT id, role FROM Invitations WHERE code_hash = ? AND expires_at >= unixepoch()`
+
+const DeleteInvitationQuery = `DELETE FROM Invitations WHERE id = ?`
@@ -3,19 +3,36 @@ package db
 import (
 	"crypto/rand"
 	"database/sql"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"sync"
 
 	"github.com/musannif-md/musannif/internal/db/queries"
 	"github.com/musannif-md/musannif/internal/utils"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const dbname string = "musannif.db"
 
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+
+	ErrTokenRevoked = errors.New("token revoked")
+	ErrTokenReused  = errors.New("refresh token reused")
+
+	// Whether the username or the password was wrong is deliberately left out
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserDisabled       = errors.New("user is disabled")
+
+	// Taking away the only active admin would leave nobody to manage users
+	ErrLastAdmin = errors.New("user is the last admin")
+)
+
 var db *sql.DB
 
 func InitTestDb() error {
@@ -26,6 +43,9 @@ func InitTestDb() error {
 		return fmt.Errorf("failed to open test database: %v", err)
 	}
 
+	// Every new connection to ":memory:" gets its own empty database
+	db.SetMaxOpenConns(1)
+
 	if err = db.Ping(); err != nil {
 		return fmt.Errorf("failed to verify test database connection: %w", err)
 	}
@@ -63,7 +83,9 @@ func InitDb(dir string) error {
 	path := filepath.Join(dir, dbname)
 	var err error
 
-	db, err = sql.Open("sqlite3", path)
+	// Pragmas only apply to the connection they're run on, so foreign keys are
+	// also requested via the DSN for any other connection in the pool
+	db, err = sql.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -108,38 +130,67 @@ func LoginUser(username, password string) (string, error) {
 		hashedPassword string
 		salt           []byte
 		role           string
+		disabled       bool
 	)
 
-	err := db.QueryRow(queries.GetUserQuery, username).Scan(&role, &hashedPassword, &salt)
+	err := db.QueryRow(queries.GetUserQuery, username).Scan(&role, &hashedPassword, &salt, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Hash anyway, so that response times don't tell whether the user exists
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return "", ErrInvalidCredentials
+	}
 	if err != nil {
 		return "", fmt.Errorf("database error: %w", err)
 	}
 
 	saltedPassword := append([]byte(password), salt...)
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), saltedPassword); err != nil {
-		return "", fmt.Errorf("invalid password")
+		return "", ErrInvalidCredentials
+	}
+
+	// Only told to those who know the password
+	if disabled {
+		return "", ErrUserDisabled
 	}
 
-	return role, nil
+	return utils.NormalizeRole(role), nil
 }
 
-func SignupUser(username, password, role string) error {
+func hashPassword(password string) (string, []byte, error) {
 	salt := make([]byte, 16)
 	if _, err := rand.Read(salt); err != nil {
-		return fmt.Errorf("failed to generate salt: %w", err)
+		return "", nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
 	saltedPassword := append([]byte(password), salt...)
 	hashedPassword, err := bcrypt.GenerateFromPassword(saltedPassword, bcrypt.DefaultCost)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return "", nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return string(hashedPassword), salt, nil
+}
+
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Returns ErrConflict if the username is taken
+func SignupUser(username, password, role string) error {
+	hashedPassword, salt, err := hashPassword(password)
+	if err != nil {
+		return err
 	}
 
 	_, err = db.Exec(
 		queries.InsertUserQuery,
-		username, role, string(hashedPassword), salt,
+		username, role, hashedPassword, salt,
 	)
 
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -147,12 +198,21 @@ func SignupUser(username, password, role string) error {
 	return nil
 }
 
-func CreateNote(username, notename string) (int64, error) {
-	result, err := db.Exec(queries.InsertNoteQuery, username, notename)
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
 
-	// TODO if err type is because a note w/ the same name exists already,
-	// throw a different error
+// Satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
 
+func createNote(e execer, username, notename string) (int64, error) {
+	result, err := e.Exec(queries.InsertNoteQuery, username, notename)
+	if isUniqueViolation(err) {
+		return 0, ErrConflict
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to create note: %w", err)
 	}
@@ -165,16 +225,88 @@ func CreateNote(username, notename string) (int64, error) {
 	return id, nil
 }
 
-func DeleteNote(username, notename string) error {
-	_, err := db.Exec(queries.DeleteNoteQuery, username, notename)
+func renameNote(e execer, username, notename, newName string) error {
+	result, err := e.Exec(queries.RenameNoteQuery, newName, username, notename)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename note: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return err
+	}
+
+	_, err = e.Exec(queries.RenamePublishedPathQuery, newName, username, notename)
+	if err != nil {
+		return fmt.Errorf("failed to rename published path: %w", err)
+	}
+
+	return nil
+}
+
+func deleteNote(e execer, username, notename string) error {
+	result, err := e.Exec(queries.DeleteNoteQuery, username, notename)
 	if err != nil {
 		return fmt.Errorf("failed to delete note: %w", err)
 	}
 
+	err = expectAffected(result)
+	if err != nil {
+		return err
+	}
+
+	// Versions went away with the note
+	_, err = e.Exec(queries.DeleteUnreferencedBlobsQuery)
+	if err != nil {
+		return fmt.Errorf("failed to delete unreferenced note blobs: %w", err)
+	}
+
 	return nil
 }
 
+// Returns ErrNotFound if a statement didn't touch any rows
+func expectAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func CreateNote(username, notename string) (int64, error) {
+	return createNote(db, username, notename)
+}
+
+func GetNoteId(username, notename string) (int64, error) {
+	return getNoteId(db, username, notename)
+}
+
+func RenameNote(username, notename, newName string) error {
+	return renameNote(db, username, notename, newName)
+}
+
+func DeleteNote(username, notename string) error {
+	return deleteNote(db, username, notename)
+}
+
 func GetUserNoteMetadata(username string) ([]utils.NoteMetadata, error) {
+	properties, err := getUserNoteProperties(username)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := getUserNoteTags(username)
+	if err != nil {
+		return nil, err
+	}
+
 	rows, err := db.Query(queries.GetUsersNotesMetadata, username)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get metadata for user's notes: %w", err)
@@ -202,6 +334,8 @@ func GetUserNoteMetadata(username string) ([]utils.NoteMetadata, error) {
 			Name:         notename,
 			CreatedAt:    strconv.FormatInt(createdAt, 10),
 			LastModified: strconv.FormatInt(lastModified, 10),
+			Properties:   properties[noteId],
+			Tags:         tags[noteId],
 		}
 
 		noteListMd = append(noteListMd, md)
@@ -214,3 +348,29 @@ func GetUserNoteMetadata(username string) ([]utils.NoteMetadata, error) {
 
 	return noteListMd, nil
 }
+
+func GetUsernames() ([]string, error) {
+	rows, err := db.Query(queries.GetUsernamesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usernames: %w", err)
+	}
+	defer rows.Close()
+
+	usernames := []string{}
+
+	for rows.Next() {
+		var username string
+		if err = rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to scan username: %w", err)
+		}
+
+		usernames = append(usernames, username)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return usernames, nil
+}
@@ -1,7 +1,12 @@
 package db
 
 import (
+	"errors"
+	"slices"
+	"strconv"
 	"testing"
+
+	"github.com/musannif-md/musannif/internal/utils"
 )
 
 var (
@@ -31,3 +36,143 @@ func TestSignupAndLogin(t *testing.T) {
 		return
 	}
 }
+
+func TestNoteVersions(t *testing.T) {
+	err := InitTestDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CleanupTestDb()
+
+	if err = SignupUser(un, pw, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = CreateNote(un, "note.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"one", "two", "two", "one"} {
+		if _, err = RecordNoteVersion(un, "note.md", un, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := GetNoteVersions(un, "note.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Consecutive identical saves are deduplicated
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(versions))
+	}
+
+	if versions[0].Hash != versions[2].Hash || versions[0].Author != un {
+		t.Errorf("unexpected versions: %+v", versions)
+	}
+
+	id, _ := strconv.ParseInt(versions[1].Id, 10, 64)
+	content, err := GetNoteVersionContent(un, "note.md", id)
+	if err != nil || string(content) != "two" {
+		t.Errorf("expected content 'two', got %q (%v)", content, err)
+	}
+
+	if err = DeleteNote(un, "note.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = GetNoteVersions(un, "note.md"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deletion, got %v", err)
+	}
+}
+
+func TestNoteTags(t *testing.T) {
+	err := InitTestDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CleanupTestDb()
+
+	if err = SignupUser(un, pw, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, note := range []string{"a.md", "b.md"} {
+		if _, err = CreateNote(un, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = AddNoteTags(un, "a.md", []string{"work", "urgent"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = AddNoteTags(un, "b.md", []string{"work"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := GetUserTags(un)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []utils.TagCount{{Name: "urgent", Count: "1"}, {Name: "work", Count: "2"}}
+	if !slices.Equal(tags, expected) {
+		t.Errorf("unexpected tags: %+v", tags)
+	}
+
+	if err = RemoveNoteTags(un, "a.md", []string{"urgent"}); err != nil {
+		t.Fatal(err)
+	}
+
+	notes, err := GetUserNoteMetadata(un)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range notes {
+		if !slices.Equal(n.Tags, []string{"work"}) {
+			t.Errorf("unexpected tags on %s: %v", n.Name, n.Tags)
+		}
+	}
+
+	if err = AddNoteTags(un, "missing.md", []string{"work"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestProvisionOidcUser(t *testing.T) {
+	err := InitTestDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CleanupTestDb()
+
+	if err = SignupUser(un, pw, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	const issuer = "https://idp.example.com"
+
+	username, role, err := ProvisionOidcUser(issuer, "sub-1", "jane", "", utils.RoleMember)
+	if err != nil || username != "jane" || role != utils.RoleMember {
+		t.Fatalf("expected jane to be created as a member, got %q %q %v", username, role, err)
+	}
+
+	// Later logins find the same user, even if the IdP's username for them
+	// changed; role changes are left to SetUserRole
+	username, role, err = ProvisionOidcUser(issuer, "sub-1", "jane.doe", utils.RoleAdmin, utils.RoleMember)
+	if err != nil || username != "jane" || role != utils.RoleMember {
+		t.Fatalf("expected jane to be found with her current role, got %q %q %v", username, role, err)
+	}
+
+	// Local accounts aren't taken over by name
+	if _, _, err = ProvisionOidcUser(issuer, "sub-2", un, "", "user"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected conflict with local account, got %v", err)
+	}
+
+	// Claims end up as storage paths, so they're checked like any username
+	if _, _, err = ProvisionOidcUser(issuer, "sub-3", "x/../"+un, "", "user"); !errors.Is(err, utils.ErrInvalidUsername) {
+		t.Errorf("expected path-like username to be rejected, got %v", err)
+	}
+}
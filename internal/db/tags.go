@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+func addNoteTags(e execer, owner string, noteId int64, tags []string) error {
+	for _, tag := range tags {
+		_, err := e.Exec(queries.InsertTagQuery, owner, tag)
+		if err != nil {
+			return fmt.Errorf("failed to create tag: %w", err)
+		}
+
+		_, err = e.Exec(queries.InsertNoteTagQuery, noteId, owner, tag)
+		if err != nil {
+			return fmt.Errorf("failed to tag note: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func AddNoteTags(owner, notename string, tags []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	noteId, err := getNoteId(tx, owner, notename)
+	if err != nil {
+		return err
+	}
+
+	err = addNoteTags(tx, owner, noteId, tags)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit note tags: %w", err)
+	}
+
+	return nil
+}
+
+// Tags that end up on no note at all are removed
+func RemoveNoteTags(owner, notename string, tags []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	noteId, err := getNoteId(tx, owner, notename)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.Exec(queries.DeleteNoteTagQuery, noteId, owner, tag)
+		if err != nil {
+			return fmt.Errorf("failed to untag note: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(queries.DeleteUnusedTagsQuery, owner)
+	if err != nil {
+		return fmt.Errorf("failed to delete unused tags: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit note tags: %w", err)
+	}
+
+	return nil
+}
+
+func GetUserTags(username string) ([]utils.TagCount, error) {
+	rows, err := db.Query(queries.GetUserTagsQuery, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []utils.TagCount{}
+
+	for rows.Next() {
+		var (
+			name  string
+			count int64
+		)
+
+		err = rows.Scan(&name, &count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to TagCount obj: %w", err)
+		}
+
+		tags = append(tags, utils.TagCount{
+			Name:  name,
+			Count: strconv.FormatInt(count, 10),
+		})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return tags, nil
+}
+
+// Returns the tags of every note of a user, by note id
+func getUserNoteTags(username string) (map[int64][]string, error) {
+	rows, err := db.Query(queries.GetUsersNoteTagsQuery, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get note tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := map[int64][]string{}
+
+	for rows.Next() {
+		var (
+			noteId int64
+			name   string
+		)
+
+		err = rows.Scan(&noteId, &name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan note tag: %w", err)
+		}
+
+		tags[noteId] = append(tags[noteId], name)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Makes a note a template, or changes whether it's shared if it already is one.
+// Returns the template's id.
+func SetTemplate(owner, notename string, shared bool) (int64, error) {
+	noteId, err := getNoteId(db, owner, notename)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = db.Exec(queries.UpsertTemplateQuery, noteId, shared)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set template: %w", err)
+	}
+
+	return noteId, nil
+}
+
+// Stops a note from being a template; the note itself is left alone
+func RemoveTemplate(owner, notename string) error {
+	noteId, err := getNoteId(db, owner, notename)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(queries.DeleteTemplateQuery, noteId)
+	if err != nil {
+		return fmt.Errorf("failed to remove template: %w", err)
+	}
+
+	return expectAffected(result)
+}
+
+func GetTemplates(username string) ([]utils.NoteTemplate, error) {
+	rows, err := db.Query(queries.GetTemplatesQuery, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []utils.NoteTemplate{}
+
+	for rows.Next() {
+		var (
+			t         utils.NoteTemplate
+			id        int64
+			createdAt int64
+		)
+
+		err = rows.Scan(&id, &t.NoteName, &t.Owner, &t.Shared, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to NoteTemplate obj: %w", err)
+		}
+
+		t.Id = strconv.FormatInt(id, 10)
+		t.CreatedAt = strconv.FormatInt(createdAt, 10)
+		templates = append(templates, t)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return templates, nil
+}
+
+// Returns the note name and owner of a template `username` may use
+func GetTemplate(id int64, username string) (string, string, error) {
+	var notename, owner string
+
+	err := db.QueryRow(queries.GetTemplateQuery, id, username).Scan(&notename, &owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", ErrNotFound
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get template: %w", err)
+	}
+
+	return notename, owner, nil
+}
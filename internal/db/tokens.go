@@ -0,0 +1,206 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Starts a new family of refresh tokens for a user, with `tokenId` (issued at
+// `issuedAtMs`, unix time in milliseconds) as its first token. Tokens that
+// have expired are cleaned up along the way.
+func CreateRefreshTokenFamily(username, family, tokenId string, issuedAtMs, expiresAt int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{queries.DeleteExpiredRefreshTokensQuery, queries.DeleteEmptyRefreshTokenFamiliesQuery} {
+		_, err = tx.Exec(q)
+		if err != nil {
+			return fmt.Errorf("failed to clean up refresh tokens: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(queries.InsertRefreshTokenFamilyQuery, family, username, issuedAtMs)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token family: %w", err)
+	}
+
+	_, err = tx.Exec(queries.InsertRefreshTokenQuery, tokenId, family, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// Replaces refresh token `tokenId` with `newTokenId`. A token can only be used
+// once; using it again means it's been stolen (or the legitimate client is
+// racing a thief), so the whole family is revoked and ErrTokenReused returned.
+func RotateRefreshToken(username, family, tokenId, newTokenId string, expiresAt int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var usedAt, revokedAt sql.NullInt64
+
+	err = tx.QueryRow(queries.GetRefreshTokenQuery, tokenId, family, username).Scan(&usedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if revokedAt.Valid {
+		return ErrTokenRevoked
+	}
+
+	if usedAt.Valid {
+		_, err = tx.Exec(queries.RevokeRefreshTokenFamilyQuery, family)
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh token family: %w", err)
+		}
+
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit refresh token revocation: %w", err)
+		}
+
+		return ErrTokenReused
+	}
+
+	result, err := tx.Exec(queries.MarkRefreshTokenUsedQuery, tokenId)
+	if err != nil {
+		return fmt.Errorf("failed to mark refresh token as used: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queries.InsertRefreshTokenQuery, newTokenId, family, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// Ends a single login: the refresh token family can't be refreshed anymore
+func RevokeRefreshTokenFamily(username, family string) error {
+	_, err := db.Exec(queries.RevokeUserRefreshTokenFamilyQuery, family, username)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+// Keeps an access token from being accepted for the rest of its lifetime
+func RevokeToken(username, jti string, expiresAt int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(queries.DeleteExpiredRevokedTokensQuery)
+	if err != nil {
+		return fmt.Errorf("failed to clean up revoked tokens: %w", err)
+	}
+
+	_, err = tx.Exec(queries.InsertRevokedTokenQuery, jti, username, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit token revocation: %w", err)
+	}
+
+	return nil
+}
+
+// Revokes every access token issued to a user up to `before`, and every
+// refresh token family started by then. Tokens issued once this returns are
+// unaffected, even within the same millisecond as `before`.
+func RevokeTokensBefore(username string, before time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = revokeTokensBefore(tx, username, before); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit token revocation: %w", err)
+	}
+
+	waitPastCutoff(before)
+	return nil
+}
+
+func revokeTokensBefore(e execer, username string, before time.Time) error {
+	_, err := e.Exec(queries.UpsertTokenCutoffQuery, username, before.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to set token cutoff: %w", err)
+	}
+
+	_, err = e.Exec(queries.RevokeUserRefreshTokenFamiliesQuery, username, before.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token families: %w", err)
+	}
+
+	return nil
+}
+
+// Cutoffs include tokens issued during their last millisecond, since one
+// issued just before the cutoff can't be told apart from one issued just
+// after. Waiting for that millisecond to pass means that every token issued
+// afterwards is later than the cutoff.
+func waitPastCutoff(before time.Time) {
+	time.Sleep(time.Until(time.UnixMilli(before.UnixMilli() + 1)))
+}
+
+// Meant for `utils.SetRevocationChecker`; returns ErrTokenRevoked for tokens
+// that have been logged out, or whose user has been deleted
+func CheckTokenRevoked(claims *utils.CustomClaims) error {
+	var issuedAt int64
+	if claims.IssuedAt != nil {
+		// Parsing goes through a float, which can land just short of the
+		// millisecond the token was issued at
+		issuedAt = claims.IssuedAt.Round(time.Millisecond).UnixMilli()
+	}
+
+	var revoked bool
+
+	err := db.QueryRow(queries.IsTokenRevokedQuery, claims.ID, claims.Username, issuedAt, claims.Username).Scan(&revoked)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Stores a secret the user has yet to confirm with a code. Returns ErrConflict
+// if they already have two-factor authentication enabled.
+func SetPendingTotp(username, secret string) error {
+	result, err := db.Exec(queries.UpsertPendingTotpQuery, username, secret)
+	if err != nil {
+		return fmt.Errorf("failed to store totp secret: %w", err)
+	}
+
+	if err = expectAffected(result); errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	}
+
+	return err
+}
+
+// Returns ErrNotFound if the user never enrolled
+func GetTotp(username string) (secret string, enabled bool, err error) {
+	err = db.QueryRow(queries.GetTotpQuery, username).Scan(&secret, &enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, ErrNotFound
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get totp secret: %w", err)
+	}
+
+	return secret, enabled, nil
+}
+
+// Turns on the pending secret, replacing the user's recovery codes with
+// `recoveryCodes` (only their hashes are stored)
+func EnableTotp(username string, recoveryCodes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(queries.EnableTotpQuery, username)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queries.DeleteRecoveryCodesQuery, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(queries.InsertRecoveryCodeQuery, username, HashContent([]byte(utils.NormalizeRecoveryCode(code))))
+		if err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit totp enrolment: %w", err)
+	}
+
+	return nil
+}
+
+// Records that a code from time step `step` was accepted. Returns
+// ErrTokenReused if a code from that step (or a later one) already was.
+func UseTotpStep(username string, step int64) error {
+	result, err := db.Exec(queries.UseTotpStepQuery, step, username, step)
+	if err != nil {
+		return fmt.Errorf("failed to record totp step: %w", err)
+	}
+
+	if err = expectAffected(result); errors.Is(err, ErrNotFound) {
+		return ErrTokenReused
+	}
+
+	return err
+}
+
+// Uses up one of the user's recovery codes; ErrNotFound if it isn't one
+func UseRecoveryCode(username, code string) error {
+	result, err := db.Exec(queries.DeleteRecoveryCodeQuery, username, HashContent([]byte(utils.NormalizeRecoveryCode(code))))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return expectAffected(result)
+}
+
+// Turns two-factor authentication off, dropping the secret and recovery codes.
+// Returns ErrNotFound if the user never enrolled.
+func RemoveTotp(username string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(queries.DeleteTotpQuery, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete totp secret: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queries.DeleteRecoveryCodesQuery, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit totp removal: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Groups note row changes so they can be committed only once the matching
+// change to the note's content has been made in storage
+type NoteTx struct {
+	tx *sql.Tx
+}
+
+func BeginNoteTx() (*NoteTx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	return &NoteTx{tx: tx}, nil
+}
+
+func (t *NoteTx) CreateNote(username, notename string) (int64, error) {
+	return createNote(t.tx, username, notename)
+}
+
+func (t *NoteTx) RenameNote(username, notename, newName string) error {
+	return renameNote(t.tx, username, notename, newName)
+}
+
+func (t *NoteTx) DeleteNote(username, notename string) error {
+	return deleteNote(t.tx, username, notename)
+}
+
+func (t *NoteTx) CreateAttachment(noteId int64, id, filename, mimeType string, size int64) error {
+	return createAttachment(t.tx, noteId, id, filename, mimeType, size)
+}
+
+func (t *NoteTx) AddNoteTags(username string, noteId int64, tags []string) error {
+	return addNoteTags(t.tx, username, noteId, tags)
+}
+
+func (t *NoteTx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Safe to defer; does nothing once the transaction has been committed
+func (t *NoteTx) Rollback() error {
+	err := t.tx.Rollback()
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+
+	return nil
+}
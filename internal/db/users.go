@@ -0,0 +1,280 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+func getUserId(q queryRower, username string) (int64, error) {
+	var id int64
+
+	err := q.QueryRow(queries.GetUserIdQuery, username).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user id: %w", err)
+	}
+
+	return id, nil
+}
+
+// Returns ErrLastAdmin if taking admin rights away from `username` would leave
+// no active admin
+func checkNotLastAdmin(q queryRower, username string) error {
+	var last bool
+
+	err := q.QueryRow(queries.IsLastAdminQuery, username, utils.RoleAdmin, username, utils.RoleAdmin).Scan(&last)
+	if err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+
+	if last {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+func GetUsers() ([]utils.User, error) {
+	rows, err := db.Query(queries.GetUsersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []utils.User{}
+
+	for rows.Next() {
+		var (
+			u          utils.User
+			disabledAt sql.NullInt64
+			noteCount  int64
+		)
+
+		err = rows.Scan(&u.Username, &u.Role, &disabledAt, &noteCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to User obj: %w", err)
+		}
+
+		u.Role = utils.NormalizeRole(u.Role)
+		u.NoteCount = strconv.FormatInt(noteCount, 10)
+		if disabledAt.Valid {
+			u.Disabled = true
+			u.DisabledAt = strconv.FormatInt(disabledAt.Int64, 10)
+		}
+
+		users = append(users, u)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return users, nil
+}
+
+// Changes a user's role. Access tokens they already hold carry the old role,
+// so they're revoked; refreshing picks up the new one.
+func SetUserRole(username, role string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if role != utils.RoleAdmin {
+		if err = checkNotLastAdmin(tx, username); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(queries.UpdateUserRoleQuery, role, username)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	if err = expectAffected(result); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	_, err = tx.Exec(queries.UpsertTokenCutoffQuery, username, now.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to set token cutoff: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit role change: %w", err)
+	}
+
+	waitPastCutoff(now)
+	return nil
+}
+
+// Disabling a user revokes every token they hold and keeps them from logging
+// in until they're enabled again; `by` is the admin doing it
+func SetUserDisabled(username, by string, disabled bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userId, err := getUserId(tx, username)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if disabled {
+		if err = checkNotLastAdmin(tx, username); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(queries.InsertDisabledUserQuery, userId, by)
+		if err != nil {
+			return fmt.Errorf("failed to disable user: %w", err)
+		}
+
+		if err = revokeTokensBefore(tx, username, now); err != nil {
+			return err
+		}
+	} else {
+		_, err = tx.Exec(queries.DeleteDisabledUserQuery, userId)
+		if err != nil {
+			return fmt.Errorf("failed to enable user: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user status: %w", err)
+	}
+
+	if disabled {
+		waitPastCutoff(now)
+	}
+
+	return nil
+}
+
+// Every attachment of every one of the user's notes
+func GetUserAttachments(owner string) ([]utils.Attachment, error) {
+	rows, err := db.Query(queries.GetUserAttachmentsQuery, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := []utils.Attachment{}
+
+	for rows.Next() {
+		var (
+			a         utils.Attachment
+			size      int64
+			createdAt int64
+		)
+
+		err = rows.Scan(&a.Id, &a.NoteName, &a.Filename, &a.MimeType, &size, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to Attachment obj: %w", err)
+		}
+
+		a.Size = strconv.FormatInt(size, 10)
+		a.CreatedAt = strconv.FormatInt(createdAt, 10)
+		attachments = append(attachments, a)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// Hands every one of the user's notes, along with their versions, attachments
+// and tags, to `target`, prefixing their names with `folder` (which may be
+// empty). Returns ErrConflict if a prefixed name is already taken by one of
+// the target's notes.
+func (t *NoteTx) TransferNotes(username, target, folder string) error {
+	userId, err := getUserId(t.tx, username)
+	if err != nil {
+		return err
+	}
+
+	targetId, err := getUserId(t.tx, target)
+	if err != nil {
+		return err
+	}
+
+	var conflict bool
+
+	err = t.tx.QueryRow(queries.HasTransferConflictQuery, targetId, folder, userId).Scan(&conflict)
+	if err != nil {
+		return fmt.Errorf("failed to check for conflicting notes: %w", err)
+	}
+
+	if conflict {
+		return ErrConflict
+	}
+
+	// Tags belong to users, so the target gets ones of the same names
+	_, err = t.tx.Exec(queries.CopyTagsQuery, targetId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to copy tags: %w", err)
+	}
+
+	_, err = t.tx.Exec(queries.RemapNoteTagsQuery, targetId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to move note tags: %w", err)
+	}
+
+	_, err = t.tx.Exec(queries.TransferNotesQuery, targetId, folder, userId)
+	if err != nil {
+		return fmt.Errorf("failed to transfer notes: %w", err)
+	}
+
+	return nil
+}
+
+// Deletes the user along with any notes they still own, and everything else
+// that belongs to them
+func (t *NoteTx) DeleteUser(username string) error {
+	userId, err := getUserId(t.tx, username)
+	if err != nil {
+		return err
+	}
+
+	if err = checkNotLastAdmin(t.tx, username); err != nil {
+		return err
+	}
+
+	// Notes don't cascade from their user, but everything hanging off them
+	// cascades from the notes
+	_, err = t.tx.Exec(queries.DeleteUserNotesQuery, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete notes: %w", err)
+	}
+
+	_, err = t.tx.Exec(queries.DeleteUnreferencedBlobsQuery)
+	if err != nil {
+		return fmt.Errorf("failed to delete unreferenced note blobs: %w", err)
+	}
+
+	_, err = t.tx.Exec(queries.DeleteUserQuery, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/db/queries"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getNoteId(q queryRower, username, notename string) (int64, error) {
+	var noteId int64
+
+	err := q.QueryRow(queries.GetNoteIdQuery, username, notename).Scan(&noteId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get note id: %w", err)
+	}
+
+	return noteId, nil
+}
+
+func HashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Stores `content` as the newest version of a note, unless it's identical to
+// the current newest version. Reports whether a new version was recorded.
+func RecordNoteVersion(owner, notename, author string, content []byte) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	noteId, err := getNoteId(tx, owner, notename)
+	if err != nil {
+		return false, err
+	}
+
+	hash := HashContent(content)
+
+	var latest string
+	err = tx.QueryRow(queries.GetLatestNoteVersionHashQuery, noteId).Scan(&latest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to get latest version: %w", err)
+	}
+
+	if latest == hash {
+		return false, nil
+	}
+
+	_, err = tx.Exec(queries.InsertNoteBlobQuery, hash, content)
+	if err != nil {
+		return false, fmt.Errorf("failed to store note blob: %w", err)
+	}
+
+	_, err = tx.Exec(queries.InsertNoteVersionQuery, noteId, author, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert note version: %w", err)
+	}
+
+	_, err = tx.Exec(queries.UpdateNoteModificationTime, noteId)
+	if err != nil {
+		return false, fmt.Errorf("failed to update modification time: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit note version: %w", err)
+	}
+
+	return true, nil
+}
+
+func GetNoteVersions(owner, notename string) ([]utils.NoteVersion, error) {
+	noteId, err := getNoteId(db, owner, notename)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(queries.GetNoteVersionsQuery, noteId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get note versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []utils.NoteVersion{}
+
+	for rows.Next() {
+		var (
+			id        int64
+			author    string
+			createdAt int64
+			hash      string
+			size      int64
+		)
+
+		err = rows.Scan(&id, &author, &createdAt, &hash, &size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row to NoteVersion obj: %w", err)
+		}
+
+		versions = append(versions, utils.NoteVersion{
+			Id:        strconv.FormatInt(id, 10),
+			Author:    author,
+			CreatedAt: strconv.FormatInt(createdAt, 10),
+			Hash:      hash,
+			Size:      strconv.FormatInt(size, 10),
+		})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	return versions, nil
+}
+
+func GetNoteVersionContent(owner, notename string, versionId int64) ([]byte, error) {
+	noteId, err := getNoteId(db, owner, notename)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []byte
+
+	err = db.QueryRow(queries.GetNoteVersionContentQuery, noteId, versionId).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get note version: %w", err)
+	}
+
+	return content, nil
+}
@@ -0,0 +1,169 @@
+package fsck
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/indexer"
+	"github.com/musannif-md/musannif/internal/storage"
+)
+
+const noteExt = ".md"
+
+// Notes are "<owner>/<name>"
+type Report struct {
+	AdoptedFiles       []string `json:"adopted_files"`       // files that had no row; a row was created
+	DroppedRows        []string `json:"dropped_rows"`        // rows that had no file; the row was deleted
+	RemovedStaged      []string `json:"removed_staged"`      // staged content left behind; it was deleted
+	RemovedAttachments []string `json:"removed_attachments"` // attachment files that had no row; they were deleted
+}
+
+// Reconciles note rows with note storage for every user. Files without a row
+// are adopted as notes (with their content as the first version), rows
+// without a file are deleted, and so are leftover staged content and
+// attachment files without a row. With `dryRun`, only reports what would be
+// done. Changes in progress look like leftovers, so the server shouldn't be
+// running.
+func Run(dryRun bool) (Report, error) {
+	report := Report{
+		AdoptedFiles:       []string{},
+		DroppedRows:        []string{},
+		RemovedStaged:      []string{},
+		RemovedAttachments: []string{},
+	}
+
+	usernames, err := db.GetUsernames()
+	if err != nil {
+		return report, err
+	}
+
+	for _, username := range usernames {
+		err = checkUser(username, dryRun, &report)
+		if err != nil {
+			return report, fmt.Errorf("fsck of %s failed: %w", username, err)
+		}
+	}
+
+	return report, nil
+}
+
+func checkUser(username string, dryRun bool, report *Report) error {
+	files, err := storage.Store.List(username)
+	if err != nil {
+		return err
+	}
+
+	notes, err := db.GetUserNoteMetadata(username)
+	if err != nil {
+		return err
+	}
+
+	inStorage := map[string]bool{}
+	for _, f := range files {
+		if strings.HasSuffix(f, noteExt) {
+			inStorage[f] = true
+		}
+	}
+
+	inDb := map[string]bool{}
+	for _, n := range notes {
+		inDb[n.Name] = true
+	}
+
+	for _, n := range notes {
+		if inStorage[n.Name] {
+			continue
+		}
+
+		report.DroppedRows = append(report.DroppedRows, username+"/"+n.Name)
+		if dryRun {
+			continue
+		}
+
+		err = db.DeleteNote(username, n.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		if !inStorage[f] || inDb[f] {
+			continue
+		}
+
+		report.AdoptedFiles = append(report.AdoptedFiles, username+"/"+f)
+		if dryRun {
+			continue
+		}
+
+		content, err := storage.Store.Read(username, f)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.CreateNote(username, f)
+		if err != nil {
+			return err
+		}
+
+		_, err = indexer.NoteSaved(username, username, f, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return removeLeftovers(username, dryRun, report)
+}
+
+func removeLeftovers(username string, dryRun bool, report *Report) error {
+	staged, err := storage.ListStaged(username)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range staged {
+		report.RemovedStaged = append(report.RemovedStaged, username+"/"+f)
+		if dryRun {
+			continue
+		}
+
+		err = storage.Store.Delete(username, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	ids, err := storage.ListAttachments(username)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := db.GetUserAttachments(username)
+	if err != nil {
+		return err
+	}
+
+	inDb := map[string]bool{}
+	for _, a := range attachments {
+		inDb[a.Id] = true
+	}
+
+	for _, id := range ids {
+		if inDb[id] {
+			continue
+		}
+
+		report.RemovedAttachments = append(report.RemovedAttachments, username+"/"+id)
+		if dryRun {
+			continue
+		}
+
+		err = storage.Store.Delete(username, storage.AttachmentName(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package fsck
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/storage"
+)
+
+func TestRun(t *testing.T) {
+	if err := db.InitTestDb(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CleanupTestDb()
+
+	storage.Store = storage.NewFsStore(t.TempDir())
+
+	if err := db.SignupUser("username", "password", "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A row without a file, and a file without a row
+	if _, err := db.CreateNote("username", "dangling.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Store.Write("username", "orphan.md", []byte("orphan")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Staged content left behind, and attachment files with and without a row
+	if err := storage.Store.Write("username", ".staging/leftover", []byte("leftover")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateNote("username", "kept.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Store.Write("username", "kept.md", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAttachment("username", "kept.md", "kept-id", "kept.png", "image/png", 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"kept-id", "orphan-id"} {
+		if err := storage.Store.Write("username", storage.AttachmentName(id), []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Run(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(report.AdoptedFiles, []string{"username/orphan.md"}) ||
+		!slices.Equal(report.DroppedRows, []string{"username/dangling.md"}) ||
+		!slices.Equal(report.RemovedStaged, []string{"username/.staging/leftover"}) ||
+		!slices.Equal(report.RemovedAttachments, []string{"username/orphan-id"}) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if _, err = storage.Store.Read("username", ".staging/leftover"); err != nil {
+		t.Fatalf("expected a dry run to leave staged content alone, got %v", err)
+	}
+
+	if _, err = Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	notes, err := db.GetUserNoteMetadata("username")
+	if err != nil || len(notes) != 2 {
+		t.Fatalf("unexpected notes after fsck: %+v (%v)", notes, err)
+	}
+
+	if _, err = storage.Store.Read("username", storage.AttachmentName("kept-id")); err != nil {
+		t.Errorf("expected attachment with a row to be kept, got %v", err)
+	}
+
+	report, err = Run(true)
+	if err != nil || len(report.AdoptedFiles)+len(report.DroppedRows)+len(report.RemovedStaged)+len(report.RemovedAttachments) != 0 {
+		t.Errorf("expected nothing left to reconcile, got %+v (%v)", report, err)
+	}
+}
@@ -0,0 +1,205 @@
+package gitstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const (
+	branch        = "main"
+	remoteName    = "origin"
+	committerName = "musannif"
+	emailDomain   = "musannif.local"
+)
+
+// Serializes git invocations per repository; git refuses concurrent writes to
+// the same index anyway
+var repoLocks sync.Map
+
+type Repo struct {
+	dir    string
+	remote string
+}
+
+// Opens the repository at `dir`, initializing it if necessary. If `remote` is
+// non-empty, it's the path of a bare repository that commits are pushed to;
+// it's created as well if it doesn't exist yet.
+func Open(dir, remote string) (*Repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repository path: %w", err)
+	}
+
+	if remote != "" {
+		remote, err = filepath.Abs(remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve remote repository path: %w", err)
+		}
+	}
+
+	r := &Repo{dir: dir, remote: remote}
+
+	mu := r.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository directory: %w", err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, ".git"))
+	if os.IsNotExist(err) {
+		_, err = r.git("init", "-q", "-b", branch)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat repository: %w", err)
+	}
+
+	if remote == "" {
+		return r, nil
+	}
+
+	_, err = os.Stat(remote)
+	if os.IsNotExist(err) {
+		_, err = r.git("init", "-q", "--bare", "-b", branch, remote)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat remote repository: %w", err)
+	}
+
+	// Keep the remote in sync with config
+	_, err = r.git("remote", "get-url", remoteName)
+	if err != nil {
+		_, err = r.git("remote", "add", remoteName, remote)
+	} else {
+		_, err = r.git("remote", "set-url", remoteName, remote)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Repo) lock() *sync.Mutex {
+	mu, _ := repoLocks.LoadOrStore(r.dir, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	return r.gitAs(committerName, args...)
+}
+
+func (r *Repo) gitAs(author string, args ...string) (string, error) {
+	subcommand := args[0]
+
+	// Don't let the host's git config (signing, hooks, etc.) interfere
+	args = append([]string{
+		"-c", "commit.gpgsign=false",
+		"-c", "core.hooksPath=/dev/null",
+		"-c", "user.name=" + committerName,
+		"-c", "user.email=" + committerName + "@" + emailDomain,
+	}, args...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME="+author,
+		"GIT_AUTHOR_EMAIL="+author+"@"+emailDomain,
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", subcommand, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// Stages `paths` (relative to the repository, including deletions) and commits
+// them as `author`. Does nothing if none of them changed.
+func (r *Repo) Commit(author, message string, paths ...string) error {
+	mu := r.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	args := append([]string{"add", "-A", "--"}, paths...)
+	_, err := r.git(args...)
+	if err != nil {
+		return err
+	}
+
+	// Exits with 1 if there are staged changes
+	_, err = r.git("diff", "--cached", "--quiet")
+	if err == nil {
+		return nil
+	}
+
+	_, err = r.gitAs(author, "commit", "-q", "-m", message)
+	if err != nil {
+		return err
+	}
+
+	if r.remote != "" {
+		_, err = r.git("push", "-q", remoteName, "HEAD:"+branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the commits touching `path`, newest first, following renames
+func (r *Repo) Log(path string) ([]utils.NoteCommit, error) {
+	mu := r.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	// An empty repository has no HEAD to log from
+	_, err := r.git("rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		return []utils.NoteCommit{}, nil
+	}
+
+	out, err := r.git("log", "--follow", "--format=%H%x1f%an%x1f%at%x1f%s", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []utils.NoteCommit{}
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\x1f", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		commits = append(commits, utils.NoteCommit{
+			Hash:        fields[0],
+			Author:      fields[1],
+			CommittedAt: fields[2],
+			Message:     fields[3],
+		})
+	}
+
+	return commits, nil
+}
@@ -0,0 +1,69 @@
+package gitstore
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommitAndPushToLocalBareRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	dir := filepath.Join(t.TempDir(), "username")
+	remote := filepath.Join(t.TempDir(), "username.git")
+
+	repo, err := Open(dir, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := repo.Log("note.md")
+	if err != nil || len(commits) != 0 {
+		t.Fatalf("expected empty history, got %v (%v)", commits, err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "note.md"), []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Commit("alice", "Create note.md", "note.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed, so no commit
+	if err = repo.Commit("alice", "Update note.md", "note.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(filepath.Join(dir, "note.md"), filepath.Join(dir, "renamed.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Commit("bob", "Rename note.md to renamed.md", "note.md", "renamed.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err = repo.Log("renamed.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commits) != 2 || commits[0].Author != "bob" || commits[1].Author != "alice" {
+		t.Fatalf("unexpected history: %+v", commits)
+	}
+
+	out, err := exec.Command("git", "--git-dir", remote, "log", "--format=%s", "main").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(out), "Rename note.md to renamed.md") {
+		t.Errorf("remote wasn't pushed to, log: %q", out)
+	}
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/publish"
+	"github.com/musannif-md/musannif/internal/resolver"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+type userCreateReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"` // defaults to member
+}
+
+type userRoleReq struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type userDisableReq struct {
+	Username string `json:"username"`
+	Disabled bool   `json:"disabled"` // false enables the user again
+}
+
+type userDeleteReq struct {
+	Username   string `json:"username"`
+	TransferTo string `json:"transfer_to,omitempty"` // who gets the user's notes; they're deleted if empty
+	Folder     string `json:"folder,omitempty"`      // put transferred notes under this folder
+}
+
+type userDeleteResp struct {
+	TransferredNotes []string `json:"transferred_notes,omitempty"` // their names under the new owner
+}
+
+// Writes the response for errors shared by the user management handlers
+func handleUserErr(w http.ResponseWriter, err error, action string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrLastAdmin):
+		http.Error(w, "Can't take away the last active admin", http.StatusConflict)
+	default:
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msgf("failed to %s", action)
+	}
+
+	return true
+}
+
+func FetchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetUsers()
+	if err != nil {
+		http.Error(w, "Failed to get users", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// Creates an account for someone else, e.g. when signing up is disabled
+func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req userCreateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := utils.ValidateUsername(req.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = utils.RoleMember
+	}
+
+	if !utils.ValidRole(req.Role) {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	if err := utils.ValidatePassword(req.Username, req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.SignupUser(req.Username, req.Password, req.Role)
+	if errors.Is(err, db.ErrConflict) {
+		http.Error(w, "Username is taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to create user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(utils.User{
+		Username:  req.Username,
+		Role:      req.Role,
+		NoteCount: "0",
+	})
+}
+
+// Changes a user's role. Their access tokens are revoked, so the new role
+// applies from their next refresh.
+func SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req userRoleReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !utils.ValidRole(req.Role) {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	err := db.SetUserRole(req.Username, req.Role)
+	if handleUserErr(w, err, "change role") {
+		return
+	}
+
+	// Open sessions were authorized under the old role
+	resolver.CloseUserSessions(req.Username)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Disables a user, logging them out everywhere and keeping them out, or
+// enables them again. Their notes are left alone.
+func SetUserDisabledHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req userDisableReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Disabled && req.Username == username {
+		http.Error(w, "Can't disable yourself", http.StatusBadRequest)
+		return
+	}
+
+	err := db.SetUserDisabled(req.Username, username, req.Disabled)
+	if handleUserErr(w, err, "change user status") {
+		return
+	}
+
+	if req.Disabled {
+		resolver.CloseUserSessions(req.Username)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Deletes a user, either handing their notes to another user (optionally
+// under a folder) or deleting them too. Links in transferred notes aren't
+// rewritten: with a folder, relative markdown links between them keep
+// working, but wiki-links end up pointing outside of it.
+func DeleteUser(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		var req userDeleteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Username == username {
+			http.Error(w, "Can't delete yourself", http.StatusBadRequest)
+			return
+		}
+
+		if req.TransferTo == req.Username {
+			http.Error(w, "Can't transfer notes to the user being deleted", http.StatusBadRequest)
+			return
+		}
+
+		folder := strings.Trim(req.Folder, "/")
+		if folder != "" {
+			if req.TransferTo == "" {
+				http.Error(w, "A folder needs a user to transfer notes to", http.StatusBadRequest)
+				return
+			}
+			folder += "/"
+		}
+
+		notes, err := db.GetUserNoteMetadata(req.Username)
+		if handleUserErr(w, err, "delete user") {
+			return
+		}
+
+		attachments, err := db.GetUserAttachments(req.Username)
+		if handleUserErr(w, err, "delete user") {
+			return
+		}
+
+		var transferred []utils.ArchiveFile
+
+		if req.TransferTo != "" {
+			transferred, err = transferNotes(req.Username, req.TransferTo, folder, notes, attachments)
+		} else {
+			err = purgeUser(req.Username)
+		}
+
+		if errors.Is(err, db.ErrConflict) {
+			http.Error(w, "Some notes have the same names as the other user's; transfer them to a folder", http.StatusConflict)
+			return
+		}
+		if handleUserErr(w, err, "delete user") {
+			return
+		}
+
+		// The user is gone as far as anyone can tell, so what follows is
+		// only cleanup
+		removeUserFiles(cfg, req.Username, notes, attachments)
+		resolver.CloseUserSessions(req.Username)
+
+		// Their published paths went with them, which takes the site down
+		if err := publish.Rebuild(req.Username); err != nil {
+			logger.Log.Error().Err(err).Msgf("failed to remove site of %s", req.Username)
+		}
+
+		names := make([]string, 0, len(transferred))
+
+		for _, f := range transferred {
+			names = append(names, f.Name)
+
+			// Relative links resolve differently under a folder
+			err := db.SetNoteLinks(req.TransferTo, f.Name, utils.ParseLinks(f.Name, string(f.Content)))
+			if err != nil {
+				logger.Log.Error().Err(err).Msgf("failed to index links of %s/%s", req.TransferTo, f.Name)
+			}
+		}
+
+		if len(names) > 0 {
+			commitNotes(cfg, req.TransferTo, username, "Transfer notes from "+req.Username, names...)
+			publish.NotesChanged(req.TransferTo, names...)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(userDeleteResp{TransferredNotes: names})
+	}
+}
+
+// Copies the user's notes and attachments to `target`, then deletes the user
+// with their rows moved over. Nothing changes if an error is returned.
+// Returns the notes under their new names.
+func transferNotes(username, target, folder string, notes []utils.NoteMetadata, attachments []utils.Attachment) (transferred []utils.ArchiveFile, err error) {
+	tx, err := db.BeginNoteTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Checks for clashing names before anything is copied
+	if err = tx.TransferNotes(username, target, folder); err != nil {
+		return nil, err
+	}
+
+	var (
+		staged []*storage.Staged
+		stored []utils.Attachment
+	)
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, s := range staged {
+			if err := s.Rollback(); err != nil {
+				logger.Log.Error().Err(err).Msg("failed to roll back transferred note")
+			}
+		}
+		deleteAttachmentFiles(target, stored)
+	}()
+
+	for _, n := range notes {
+		var content []byte
+
+		// A row without a file stays that way for fsck to sort out
+		content, err = storage.Store.Read(username, n.Name)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var s *storage.Staged
+
+		s, err = storage.StageWrite(target, folder+n.Name, content)
+		if err != nil {
+			return nil, err
+		}
+
+		staged = append(staged, s)
+		transferred = append(transferred, utils.ArchiveFile{Name: folder + n.Name, Content: content})
+	}
+
+	// Attachments keep their ids, so links to them still work
+	for _, a := range attachments {
+		var content []byte
+
+		content, err = storage.Store.Read(username, storage.AttachmentName(a.Id))
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.Store.Write(target, storage.AttachmentName(a.Id), content)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, a)
+	}
+
+	for _, s := range staged {
+		if err = s.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.DeleteUser(username); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return transferred, nil
+}
+
+// Deletes the user and their notes' rows; their files are removed afterwards
+func purgeUser(username string) error {
+	tx, err := db.BeginNoteTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = tx.DeleteUser(username); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Removes what a deleted user left in storage. Failures are only logged,
+// since nothing refers to these files anymore.
+func removeUserFiles(cfg *config.AppConfig, username string, notes []utils.NoteMetadata, attachments []utils.Attachment) {
+	noteRepos.Delete(username)
+
+	if cfg.Storage.Backend != "" && cfg.Storage.Backend != storage.BackendFs {
+		for _, n := range notes {
+			err := storage.Store.Delete(username, n.Name)
+			if err != nil && !errors.Is(err, storage.ErrNotExist) {
+				logger.Log.Error().Err(err).Msgf("failed to delete note %s of %s", n.Name, username)
+			}
+		}
+		deleteAttachmentFiles(username, attachments)
+		return
+	}
+
+	// The whole directory goes, along with staged content and the git
+	// repository. Usernames are validated when users are created; this is only
+	// in case one slipped through.
+	if username == "" || strings.ContainsAny(username, `/\`) || strings.HasPrefix(username, ".") {
+		logger.Log.Error().Msgf("not removing note directory of user %q", username)
+		return
+	}
+
+	err := os.RemoveAll(filepath.Join(cfg.App.NoteDirectory, username))
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to remove note directory of %s", username)
+	}
+}
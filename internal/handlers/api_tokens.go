@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+type apiTokenCreateReq struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at,omitempty"` // unix time; never expires if empty
+}
+
+type apiTokenCreateResp struct {
+	utils.ApiToken
+	Token string `json:"token"` // only ever shown here
+}
+
+type apiTokenDeleteReq struct {
+	Id string `json:"token_id"`
+}
+
+// Creates an API token for scripts to authenticate with instead of logging in
+func CreateApiTokenHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req apiTokenCreateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Token name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := utils.ValidateScopes(req.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt int64
+	if req.ExpiresAt != "" {
+		var err error
+
+		expiresAt, err = strconv.ParseInt(req.ExpiresAt, 10, 64)
+		if err != nil || expiresAt <= time.Now().Unix() {
+			http.Error(w, "`expires_at` must be a unix time in the future", http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, err := utils.GenerateApiToken()
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to generate api token")
+		return
+	}
+
+	id, err := db.CreateApiToken(username, req.Name, token, req.Scopes, expiresAt)
+	if errors.Is(err, db.ErrConflict) {
+		http.Error(w, "A token with that name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to create api token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiTokenCreateResp{
+		ApiToken: utils.ApiToken{
+			Id:        strconv.FormatInt(id, 10),
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			CreatedAt: strconv.FormatInt(time.Now().Unix(), 10),
+			ExpiresAt: req.ExpiresAt,
+		},
+		Token: token,
+	})
+}
+
+// Lists the user's API tokens, without the tokens themselves
+func FetchApiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	tokens, err := db.GetApiTokens(username)
+	if err != nil {
+		http.Error(w, "Failed to fetch tokens", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get api tokens")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Revokes one of the user's API tokens
+func DeleteApiTokenHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req apiTokenDeleteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseInt(req.Id, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	err = db.DeleteApiToken(username, id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to delete api token")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
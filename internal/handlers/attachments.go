@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/publish"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxAttachmentSize int64 = 10 << 20
+
+	// Room for the rest of the multipart body around the file itself
+	multipartOverhead int64 = 1 << 20
+)
+
+var defaultAttachmentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"text/plain; charset=utf-8",
+}
+
+type attachmentReq struct {
+	AttachmentId string `json:"attachment_id"`
+}
+
+func maxAttachmentSize(cfg *config.AppConfig) int64 {
+	if cfg.App.MaxAttachmentSize > 0 {
+		return cfg.App.MaxAttachmentSize
+	}
+
+	return defaultMaxAttachmentSize
+}
+
+func attachmentTypes(cfg *config.AppConfig) []string {
+	if len(cfg.App.AttachmentTypes) > 0 {
+		return cfg.App.AttachmentTypes
+	}
+
+	return defaultAttachmentTypes
+}
+
+// Removes attachments' content from storage once their rows are gone. Leftovers
+// are hidden and harmless, so failures are only logged.
+func deleteAttachmentFiles(owner string, attachments []utils.Attachment) {
+	for _, a := range attachments {
+		err := storage.Store.Delete(owner, storage.AttachmentName(a.Id))
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			logger.Log.Error().Err(err).Msgf("failed to delete attachment %s", a.Id)
+		}
+	}
+}
+
+// Expects a multipart form with `note_name` and `file`
+func UploadAttachment(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maxSize := maxAttachmentSize(cfg)
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+
+		err := r.ParseMultipartForm(maxSize)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "attachment too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		noteName := r.FormValue("note_name")
+		if noteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file not provided", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+		if err != nil {
+			http.Error(w, "failed to read attachment", http.StatusBadRequest)
+			return
+		}
+
+		if int64(len(content)) > maxSize {
+			http.Error(w, "attachment too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Go by the content rather than what the client claims it is
+		mimeType := http.DetectContentType(content)
+		if !slices.Contains(attachmentTypes(cfg), mimeType) {
+			http.Error(w, "attachment type not allowed: "+mimeType, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		noteName += ".md"
+
+		_, err = db.GetNoteId(username, noteName)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		a := utils.Attachment{
+			Id:       uuid.NewString(),
+			NoteName: noteName,
+			Filename: filepath.Base(header.Filename),
+			MimeType: mimeType,
+			Size:     strconv.Itoa(len(content)),
+		}
+
+		err = storage.Store.Write(username, storage.AttachmentName(a.Id), content)
+		if err != nil {
+			http.Error(w, "failed to store attachment", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to store attachment")
+			return
+		}
+
+		err = db.CreateAttachment(username, noteName, a.Id, a.Filename, a.MimeType, int64(len(content)))
+		if err != nil {
+			http.Error(w, "failed to create attachment in DB", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to create attachment in DB")
+
+			deleteAttachmentFiles(username, []utils.Attachment{a})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// Only the owner of the attachment's note may download it, as with `FetchNoteData`
+func DownloadAttachment(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		a, owner, err := db.GetAttachment(r.PathValue("id"))
+		if err == nil && owner != username {
+			err = db.ErrNotFound
+		}
+		if handleLookupErr(w, err, "attachment") {
+			return
+		}
+
+		content, err := storage.Store.Read(owner, storage.AttachmentName(a.Id))
+		if err != nil {
+			http.Error(w, "failed to read attachment", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to read attachment")
+			return
+		}
+
+		w.Header().Set("Content-Type", a.MimeType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.Filename}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(content)
+	}
+}
+
+func FetchNoteAttachments(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteCreateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		attachments, err := db.GetNoteAttachments(username, req.NoteName)
+		if handleLookupErr(w, err, "attachments") {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attachments)
+	}
+}
+
+func DeleteAttachment(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req attachmentReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.AttachmentId == "" {
+			http.Error(w, "attachment id not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+
+		a, owner, err := db.GetAttachment(req.AttachmentId)
+		if err == nil && owner != username {
+			err = db.ErrNotFound
+		}
+		if handleLookupErr(w, err, "attachment") {
+			return
+		}
+
+		err = db.DeleteAttachment(a.Id)
+		if handleLookupErr(w, err, "attachment") {
+			return
+		}
+
+		deleteAttachmentFiles(owner, []utils.Attachment{a})
+		publish.NotesChanged(owner, a.NoteName)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -2,11 +2,26 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/musannif-md/musannif/internal/config"
 	"github.com/musannif-md/musannif/internal/db"
 	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/resolver"
 	"github.com/musannif-md/musannif/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+// How `/signup` behaves, set by `auth.signup_mode`
+const (
+	signupOpen     = "open"
+	signupInvite   = "invite"
+	signupDisabled = "disabled"
 )
 
 type loginReq struct {
@@ -14,16 +29,54 @@ type loginReq struct {
 	Password string `json:"password"`
 }
 
-// type signupReq struct {
-// 	Username string `json:"username"`
-// 	Password string `json:"password"`
-// 	Role     string `json:"role"`
-// }
+type signupReq struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Invitation string `json:"invitation,omitempty"` // code from an admin; sets the role, and is required in "invite" mode
+}
 
 type authResp struct {
-	Message string `json:"message,omitempty"`
-	Role    string `json:"role,omitempty"`
-	Token   string `json:"token,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Role         string `json:"role,omitempty"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	MfaToken     string `json:"mfa_token,omitempty"` // instead of the tokens above, when a second factor is needed
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type logoutReq struct {
+	RefreshToken string `json:"refresh_token,omitempty"` // also ends the login it came from
+}
+
+type logoutAllReq struct {
+	Before string `json:"before,omitempty"` // unix time; tokens issued before it are revoked. Defaults to now.
+}
+
+type logoutAllResp struct {
+	ClosedSessions string `json:"closed_sessions"` // live websocket connections that were closed
+}
+
+// Issues an access token along with the first refresh token of a new family
+func startSession(username, role string) (string, string, error) {
+	refreshToken, claims, err := utils.GenerateRefreshToken(username, uuid.NewString())
+	if err != nil {
+		return "", "", err
+	}
+
+	err = db.CreateRefreshTokenFamily(username, claims.Family, claims.ID, claims.IssuedAt.UnixMilli(), claims.ExpiresAt.Unix())
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := utils.GenerateToken(username, role)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, refreshToken, nil
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -33,13 +86,53 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if loginThrottled(w, r, req.Username) {
+		return
+	}
+
 	role, err := db.LoginUser(req.Username, req.Password)
+	if errors.Is(err, db.ErrInvalidCredentials) {
+		loginFailed(r, req.Username, "invalid credentials")
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, db.ErrUserDisabled) {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		logger.Log.Err(err).Msg("Failed to log in")
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	_, totpEnabled, err := db.GetTotp(req.Username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		logger.Log.Err(err).Msg("Failed to get totp secret")
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	// The password alone isn't enough; `LoginTotpHandler` finishes the job
+	if totpEnabled {
+		mfaToken, err := utils.GenerateMfaToken(req.Username)
+		if err != nil {
+			logger.Log.Err(err).Msg("Failed to generate token")
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(authResp{
+			Message:  "Two-factor authentication required",
+			MfaToken: mfaToken,
+		})
 		return
 	}
 
-	token, err := utils.GenerateToken(req.Username)
+	loginSucceeded(r, req.Username)
+
+	token, refreshToken, err := startSession(req.Username, role)
 	if err != nil {
 		logger.Log.Err(err).Msg("Failed to generate token")
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
@@ -47,41 +140,246 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := authResp{
-		Message: "Login successful",
-		Role:    role,
-		Token:   token,
+		Message:      "Login successful",
+		Role:         role,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func SignupHandler(w http.ResponseWriter, r *http.Request) {
-	var req loginReq
+// Lets anyone create a member account, or whoever has an invitation an
+// account with the invitation's role. In "invite" mode an invitation is
+// required, and in "disabled" mode (or an unknown one) nobody can sign up.
+func SignupHandler(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := cfg.Auth.SignupMode
+		if mode == "" {
+			mode = signupOpen
+		}
+
+		if mode != signupOpen && mode != signupInvite {
+			http.Error(w, "Signing up is disabled; ask an admin for an account", http.StatusForbidden)
+			return
+		}
+
+		var req signupReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if mode == signupInvite && req.Invitation == "" {
+			http.Error(w, "Signing up requires an invitation", http.StatusForbidden)
+			return
+		}
+
+		if err := utils.ValidateUsername(req.Username); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := utils.ValidatePassword(req.Username, req.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		role := utils.RoleMember
+		var err error
+
+		if req.Invitation != "" {
+			// Codes are long enough not to be guessed, but guessing is still
+			// slowed down like it is for passwords
+			if loginThrottled(w, r, "") {
+				return
+			}
+
+			role, err = db.SignupWithInvitation(req.Invitation, req.Username, req.Password)
+			if errors.Is(err, db.ErrNotFound) {
+				loginFailed(r, "", "invalid invitation")
+				http.Error(w, "Invalid or expired invitation", http.StatusForbidden)
+				return
+			}
+		} else {
+			err = db.SignupUser(req.Username, req.Password, role)
+		}
+
+		if errors.Is(err, db.ErrConflict) {
+			http.Error(w, "Username is taken", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to sign up", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to sign up")
+			return
+		}
+
+		token, refreshToken, err := startSession(req.Username, role)
+		if err != nil {
+			logger.Log.Err(err).Msg("Failed to generate token")
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		response := authResp{
+			Message:      "Login successful",
+			Role:         role,
+			Token:        token,
+			RefreshToken: refreshToken,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Trades a refresh token for a new access token and a new refresh token. Each
+// refresh token works once; presenting one again revokes every token issued
+// from the same login.
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	err := db.SignupUser(req.Username, req.Password, "user")
+	claims, err := utils.ValidateRefreshToken(req.RefreshToken)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := utils.GenerateToken(req.Username)
+	refreshToken, newClaims, err := utils.GenerateRefreshToken(claims.Username, claims.Family)
 	if err != nil {
 		logger.Log.Err(err).Msg("Failed to generate token")
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	response := authResp{
-		Message: "Login successful",
-		Role:    "user",
-		Token:   token,
+	err = db.RotateRefreshToken(claims.Username, claims.Family, claims.ID, newClaims.ID, newClaims.ExpiresAt.Unix())
+	if errors.Is(err, db.ErrTokenReused) {
+		logger.Log.Warn().Msgf("refresh token reused for user %s; revoked its family", claims.Username)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrTokenRevoked) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to rotate refresh token")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Picks up role changes since the last refresh
+	role, err := db.GetUserRole(claims.Username)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrUserDisabled) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to get user role")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := utils.GenerateToken(claims.Username, role)
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to generate token")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(authResp{
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// Revokes the access token the request was made with and, if given, the
+// refresh token from the same login
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("claims").(*utils.CustomClaims)
+
+	// The body is optional
+	var req logoutReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := db.RevokeToken(claims.Username, claims.ID, claims.ExpiresAt.Unix())
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to revoke access token")
+		return
+	}
+
+	if req.RefreshToken != "" {
+		refreshClaims, err := utils.ValidateRefreshToken(req.RefreshToken)
+		if err != nil || refreshClaims.Username != claims.Username {
+			http.Error(w, "Invalid refresh token", http.StatusBadRequest)
+			return
+		}
+
+		err = db.RevokeRefreshTokenFamily(claims.Username, refreshClaims.Family)
+		if err != nil {
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to revoke refresh token family")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResp{Message: "Logout successful"})
+}
+
+// Revokes every token issued to the user before the given time, including the
+// one the request was made with, and closes their live editing sessions
+func LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req logoutAllReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	before := now
+
+	if req.Before != "" {
+		secs, err := strconv.ParseInt(req.Before, 10, 64)
+		if err != nil || secs > now.Unix() {
+			http.Error(w, "`before` must be a unix time that isn't in the future", http.StatusBadRequest)
+			return
+		}
+
+		// The whole second counts, up to now
+		before = time.Unix(secs+1, 0).Add(-time.Millisecond)
+		if before.After(now) {
+			before = now
+		}
+	}
+
+	err := db.RevokeTokensBefore(username, before)
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to revoke tokens")
+		return
+	}
+
+	// Sessions are opened with tokens that may have been issued after an
+	// earlier cutoff, so they're only closed when logging out of everything
+	closed := 0
+	if !before.Before(now) {
+		closed = resolver.CloseUserSessions(username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logoutAllResp{ClosedSessions: strconv.Itoa(closed)})
 }
@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/middlewares"
+	"github.com/musannif-md/musannif/internal/notify"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+func login(t *testing.T, username string) authResp {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	LoginHandler(w, jsonReq(t, loginReq{Username: username, Password: "password"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to log in: %d %s", w.Code, w.Body)
+	}
+
+	var resp authResp
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp
+}
+
+func refresh(t *testing.T, refreshToken string) (authResp, int) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	RefreshTokenHandler(w, jsonReq(t, refreshReq{RefreshToken: refreshToken}))
+
+	var resp authResp
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp, w.Code
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+
+	first := login(t, "alice")
+	if first.Token == "" || first.RefreshToken == "" {
+		t.Fatalf("expected access and refresh tokens, got %+v", first)
+	}
+
+	if _, err := utils.ValidateToken(first.RefreshToken); err == nil {
+		t.Error("refresh tokens shouldn't pass as access tokens")
+	}
+
+	second, code := refresh(t, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected refresh token to be rotated, got %d", code)
+	}
+
+	if _, err := utils.ValidateToken(second.Token); err != nil {
+		t.Errorf("expected a valid access token, got %v", err)
+	}
+
+	// Replaying a used token gives the theft away, and takes the newer tokens
+	// down with it
+	if _, code = refresh(t, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expected reused refresh token to be rejected, got %d", code)
+	}
+
+	if _, code = refresh(t, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expected refresh token family to be revoked, got %d", code)
+	}
+
+	// Other logins are unaffected
+	if _, code = refresh(t, login(t, "alice").RefreshToken); code != http.StatusOK {
+		t.Errorf("expected a new login to refresh, got %d", code)
+	}
+}
+
+// Guards `h` the way the router does admin routes
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return middlewares.RequirePermission(utils.PermUsersManage, h)
+}
+
+// Calls `h` the way the router would, with `token` as the bearer token
+func authed(t *testing.T, h http.HandlerFunc, token string, body any) int {
+	t.Helper()
+
+	r := jsonReq(t, body)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	middlewares.AuthMiddleware(h)(w, r)
+	return w.Code
+}
+
+func TestLogout(t *testing.T) {
+	setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+	utils.SetRevocationChecker(db.CheckTokenRevoked)
+	t.Cleanup(func() { utils.SetRevocationChecker(nil) })
+
+	first, second := login(t, "alice"), login(t, "alice")
+
+	code := authed(t, LogoutHandler, first.Token, logoutReq{RefreshToken: first.RefreshToken})
+	if code != http.StatusOK {
+		t.Fatalf("failed to log out: %d", code)
+	}
+
+	if _, err := utils.ValidateToken(first.Token); err == nil {
+		t.Error("expected logged out access token to be rejected")
+	}
+
+	if _, code = refresh(t, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expected logged out refresh token to be rejected, got %d", code)
+	}
+
+	// Logging out one device leaves the others be
+	if _, err := utils.ValidateToken(second.Token); err != nil {
+		t.Errorf("expected other login's access token to be valid, got %v", err)
+	}
+
+	// Cutoffs in the future would lock the user out for good
+	future := logoutAllReq{Before: "99999999999"}
+	if code = authed(t, LogoutAllHandler, second.Token, future); code != http.StatusBadRequest {
+		t.Errorf("expected future cutoff to be rejected, got %d", code)
+	}
+
+	if code = authed(t, LogoutAllHandler, second.Token, logoutAllReq{}); code != http.StatusOK {
+		t.Fatalf("failed to log out of all devices: %d", code)
+	}
+
+	if _, err := utils.ValidateToken(second.Token); err == nil {
+		t.Error("expected access tokens issued before the cutoff to be rejected")
+	}
+
+	if _, code = refresh(t, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expected refresh tokens issued before the cutoff to be rejected, got %d", code)
+	}
+
+	// Logins right after the cutoff work as usual, even within the same second
+	if _, err := utils.ValidateToken(login(t, "alice").Token); err != nil {
+		t.Errorf("expected a new login to be valid, got %v", err)
+	}
+}
+
+type recordingNotifier struct {
+	username, token string
+}
+
+func (n *recordingNotifier) PasswordReset(username, token string, expiresAt time.Time) error {
+	n.username, n.token = username, token
+	return nil
+}
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	cfg := setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+	utils.SetRevocationChecker(db.CheckTokenRevoked)
+	t.Cleanup(func() { utils.SetRevocationChecker(nil) })
+
+	if err := db.SignupUser("root", "password", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	session := login(t, "alice")
+
+	wrong := passwordChangeReq{OldPassword: "wrong", NewPassword: "kiwi-Fruit-7"}
+	if code := authed(t, ChangePasswordHandler, session.Token, wrong); code != http.StatusUnauthorized {
+		t.Errorf("expected wrong old password to be rejected, got %d", code)
+	}
+
+	weak := passwordChangeReq{OldPassword: "password", NewPassword: "short"}
+	if code := authed(t, ChangePasswordHandler, session.Token, weak); code != http.StatusBadRequest {
+		t.Errorf("expected weak password to be rejected, got %d", code)
+	}
+
+	r := jsonReq(t, passwordChangeReq{OldPassword: "password", NewPassword: "kiwi-Fruit-7"})
+	r.Header.Set("Authorization", "Bearer "+session.Token)
+	w := httptest.NewRecorder()
+	middlewares.AuthMiddleware(ChangePasswordHandler)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to change password: %d %s", w.Code, w.Body)
+	}
+
+	var changed authResp
+	json.NewDecoder(w.Body).Decode(&changed)
+
+	if _, err := utils.ValidateToken(session.Token); err == nil {
+		t.Error("expected the token used to change the password to be revoked")
+	}
+	if _, err := utils.ValidateToken(changed.Token); err != nil {
+		t.Errorf("expected a fresh token, got %v", err)
+	}
+	if _, err := db.LoginUser("alice", "kiwi-Fruit-7"); err != nil {
+		t.Errorf("expected new password to work, got %v", err)
+	}
+
+	// Resets
+	notifier := &recordingNotifier{}
+	notify.Sender = notifier
+	t.Cleanup(func() { notify.Sender = notify.LogNotifier{} })
+
+	issue := adminOnly(IssuePasswordReset(cfg))
+	if code := authed(t, issue, changed.Token, passwordResetIssueReq{Username: "root"}); code != http.StatusForbidden {
+		t.Errorf("expected non-admins to be turned away, got %d", code)
+	}
+
+	if code := authed(t, issue, login(t, "root").Token, passwordResetIssueReq{Username: "alice"}); code != http.StatusOK {
+		t.Fatalf("failed to issue password reset: %d", code)
+	}
+	if notifier.username != "alice" || notifier.token == "" {
+		t.Fatalf("expected reset token to be sent to alice, got %+v", notifier)
+	}
+
+	reset := func(password string) int {
+		w := httptest.NewRecorder()
+		ResetPasswordHandler(w, jsonReq(t, passwordResetReq{Token: notifier.token, NewPassword: password}))
+		return w.Code
+	}
+
+	if code := reset("alice-2025-pw"); code != http.StatusBadRequest {
+		t.Errorf("expected weak password to be rejected, got %d", code)
+	}
+	if code := reset("plum-Tree-42"); code != http.StatusOK {
+		t.Fatalf("failed to reset password: %d", code)
+	}
+	if code := reset("pear-Tree-42"); code != http.StatusUnauthorized {
+		t.Errorf("expected reset token to work only once, got %d", code)
+	}
+
+	if _, err := db.LoginUser("alice", "plum-Tree-42"); err != nil {
+		t.Errorf("expected reset password to work, got %v", err)
+	}
+	if _, err := utils.ValidateToken(changed.Token); err == nil {
+		t.Error("expected existing tokens to be revoked by the reset")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	cfg := setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+
+	if err := db.SignupUser("root", "password", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, "alice").Token
+
+	r := jsonReq(t, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middlewares.AuthMiddleware(EnrollTotp(cfg))(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to enrol: %d %s", w.Code, w.Body)
+	}
+
+	var enrolment totpEnrollResp
+	json.NewDecoder(w.Body).Decode(&enrolment)
+
+	if !strings.HasPrefix(enrolment.URI, "otpauth://totp/") || !strings.Contains(enrolment.URI, enrolment.Secret) {
+		t.Errorf("unexpected otpauth uri %q", enrolment.URI)
+	}
+
+	// Not enforced until confirmed
+	if resp := login(t, "alice"); resp.Token == "" {
+		t.Fatal("expected pending enrolment to leave logins alone")
+	}
+
+	code, _ := utils.TotpCode(enrolment.Secret, utils.TotpStep(time.Now()))
+
+	r = jsonReq(t, totpCodeReq{Code: code})
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	middlewares.AuthMiddleware(ConfirmTotpHandler)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to confirm enrolment: %d %s", w.Code, w.Body)
+	}
+
+	var confirmed totpConfirmResp
+	json.NewDecoder(w.Body).Decode(&confirmed)
+	if len(confirmed.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(confirmed.RecoveryCodes))
+	}
+
+	challenge := login(t, "alice")
+	if challenge.Token != "" || challenge.MfaToken == "" {
+		t.Fatalf("expected a second factor to be required, got %+v", challenge)
+	}
+
+	if _, err := utils.ValidateToken(challenge.MfaToken); err == nil {
+		t.Error("mfa tokens shouldn't pass as access tokens")
+	}
+
+	finish := func(req totpLoginReq) (authResp, int) {
+		w := httptest.NewRecorder()
+		LoginTotpHandler(w, jsonReq(t, req))
+
+		var resp authResp
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp, w.Code
+	}
+
+	// The code used to confirm can't be replayed
+	if _, code := finish(totpLoginReq{MfaToken: challenge.MfaToken, Code: code}); code != http.StatusUnauthorized {
+		t.Errorf("expected used code to be rejected, got %d", code)
+	}
+
+	recovery := strings.ToUpper(confirmed.RecoveryCodes[0])
+	resp, status := finish(totpLoginReq{MfaToken: challenge.MfaToken, RecoveryCode: recovery})
+	if status != http.StatusOK || resp.Token == "" {
+		t.Fatalf("expected recovery code to log in, got %d", status)
+	}
+
+	if _, status = finish(totpLoginReq{MfaToken: challenge.MfaToken, RecoveryCode: recovery}); status != http.StatusUnauthorized {
+		t.Errorf("expected recovery code to work only once, got %d", status)
+	}
+
+	// Admins can turn it off for users who lost their authenticator
+	if status = authed(t, adminOnly(ResetTotpHandler), resp.Token, totpResetReq{Username: "alice"}); status != http.StatusForbidden {
+		t.Errorf("expected non-admins to be turned away, got %d", status)
+	}
+
+	if status = authed(t, adminOnly(ResetTotpHandler), login(t, "root").Token, totpResetReq{Username: "alice"}); status != http.StatusOK {
+		t.Fatalf("failed to reset two-factor authentication: %d", status)
+	}
+
+	if resp := login(t, "alice"); resp.Token == "" {
+		t.Error("expected password alone to log in after the reset")
+	}
+}
+
+func TestApiTokens(t *testing.T) {
+	cfg := setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+
+	jwt := login(t, "alice").Token
+
+	bad := apiTokenCreateReq{Name: "ci", Scopes: []string{"everything"}}
+	if code := authed(t, CreateApiTokenHandler, jwt, bad); code != http.StatusBadRequest {
+		t.Errorf("expected unknown scope to be rejected, got %d", code)
+	}
+
+	r := jsonReq(t, apiTokenCreateReq{Name: "ci", Scopes: []string{utils.ScopeNotesRead}})
+	r.Header.Set("Authorization", "Bearer "+jwt)
+	w := httptest.NewRecorder()
+	middlewares.AuthMiddleware(CreateApiTokenHandler)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create api token: %d %s", w.Code, w.Body)
+	}
+
+	var created apiTokenCreateResp
+	json.NewDecoder(w.Body).Decode(&created)
+	if !strings.HasPrefix(created.Token, utils.ApiTokenPrefix) {
+		t.Fatalf("unexpected token %q", created.Token)
+	}
+
+	call := func(scope string, h http.HandlerFunc, body any) int {
+		r := jsonReq(t, body)
+		r.Header.Set("Authorization", "Bearer "+created.Token)
+		w := httptest.NewRecorder()
+		middlewares.ScopedAuthMiddleware(scope, h)(w, r)
+		return w.Code
+	}
+
+	if code := call(utils.ScopeNotesRead, FetchNoteList(cfg), noteListReq{}); code != http.StatusOK {
+		t.Errorf("expected token to be able to read, got %d", code)
+	}
+
+	if code := call(utils.ScopeNotesWrite, CreateNote(cfg), noteCreateReq{NoteName: "n"}); code != http.StatusForbidden {
+		t.Errorf("expected token without the write scope to be turned away, got %d", code)
+	}
+
+	// Account routes need a login
+	if code := call("", FetchApiTokensHandler, nil); code != http.StatusForbidden {
+		t.Errorf("expected api token to be turned away from account routes, got %d", code)
+	}
+
+	w = serve(FetchApiTokensHandler, "alice", jsonReq(t, nil))
+
+	var tokens []utils.ApiToken
+	json.NewDecoder(w.Body).Decode(&tokens)
+	if len(tokens) != 1 || tokens[0].Name != "ci" || tokens[0].LastUsedAt == "" {
+		t.Fatalf("expected the used token to be listed, got %+v", tokens)
+	}
+
+	if w = serve(DeleteApiTokenHandler, "alice", jsonReq(t, apiTokenDeleteReq{Id: created.Id})); w.Code != http.StatusOK {
+		t.Fatalf("failed to revoke api token: %d", w.Code)
+	}
+
+	if code := call(utils.ScopeNotesRead, FetchNoteList(cfg), noteListReq{}); code != http.StatusUnauthorized {
+		t.Errorf("expected revoked token to be rejected, got %d", code)
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+
+	if err := db.SignupUser("root", "password", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	attemptFrom := func(ip, username, password string) *httptest.ResponseRecorder {
+		r := jsonReq(t, loginReq{Username: username, Password: password})
+		r.RemoteAddr = ip + ":1234"
+
+		w := httptest.NewRecorder()
+		LoginHandler(w, r)
+		return w
+	}
+
+	attempt := func(username, password string) *httptest.ResponseRecorder {
+		return attemptFrom("192.0.2.1", username, password)
+	}
+
+	// Unknown users and wrong passwords look alike
+	unknown, wrong := attempt("mallory", "password"), attempt("alice", "wrong")
+	if unknown.Code != http.StatusUnauthorized || unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("expected identical responses, got %d %q and %d %q", unknown.Code, unknown.Body, wrong.Code, wrong.Body)
+	}
+
+	for range clientThrottleOpts.FreeAttempts - 1 {
+		attempt("alice", "wrong")
+	}
+
+	// Even the right password has to wait now
+	w := attempt("alice", "password")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected account to be throttled, got %d", w.Code)
+	}
+
+	// Failures from one address don't keep alice out from another
+	if w = attemptFrom("198.51.100.7", "alice", "password"); w.Code != http.StatusOK {
+		t.Errorf("expected alice to log in from elsewhere, got %d", w.Code)
+	}
+
+	// Other accounts from the same address are fine
+	rootToken := login(t, "root").Token
+
+	w = serve(FetchLoginAttemptsHandler, "root", jsonReq(t, loginAttemptsReq{Username: "alice"}))
+
+	var attempts []utils.LoginAttempt
+	json.NewDecoder(w.Body).Decode(&attempts)
+	if len(attempts) != clientThrottleOpts.FreeAttempts || attempts[0].Reason != "invalid credentials" {
+		t.Errorf("expected %d recorded failures for alice, got %+v", clientThrottleOpts.FreeAttempts, attempts)
+	}
+
+	if code := authed(t, adminOnly(FetchLoginAttemptsHandler), rootToken, loginAttemptsReq{}); code != http.StatusOK {
+		t.Errorf("expected admins to list every attempt, got %d", code)
+	}
+
+	// alice can't log in for now
+	aliceToken, _ := utils.GenerateToken("alice", utils.RoleMember)
+	if code := authed(t, adminOnly(FetchLoginAttemptsHandler), aliceToken, loginAttemptsReq{}); code != http.StatusForbidden {
+		t.Errorf("expected non-admins to be turned away, got %d", code)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	cfg := setup(t, "alice")
+	utils.SetJwtKeys("access secret", "refresh secret")
+
+	if err := db.SignupUser("gail", "password", utils.RoleGuest); err != nil {
+		t.Fatal(err)
+	}
+
+	guest := login(t, "gail")
+	if guest.Role != utils.RoleGuest {
+		t.Errorf("expected guest role, got %q", guest.Role)
+	}
+
+	claims, err := utils.ValidateToken(guest.Token)
+	if err != nil || claims.Role != utils.RoleGuest {
+		t.Fatalf("expected role in token claims, got %+v %v", claims, err)
+	}
+
+	guarded := func(perm string, h http.HandlerFunc) http.HandlerFunc {
+		return middlewares.RequirePermission(perm, h)
+	}
+
+	if code := authed(t, guarded(utils.PermNotesRead, FetchNoteList(cfg)), guest.Token, noteListReq{}); code != http.StatusOK {
+		t.Errorf("expected guests to read notes, got %d", code)
+	}
+
+	if code := authed(t, guarded(utils.PermNotesWrite, CreateNote(cfg)), guest.Token, noteCreateReq{NoteName: "n"}); code != http.StatusForbidden {
+		t.Errorf("expected guests to be kept from writing, got %d", code)
+	}
+
+	// Users created before roles were enforced are members
+	member := login(t, "alice")
+	if member.Role != utils.RoleMember {
+		t.Errorf("expected legacy role to read as member, got %q", member.Role)
+	}
+
+	if code := authed(t, guarded(utils.PermNotesWrite, CreateNote(cfg)), member.Token, noteCreateReq{NoteName: "n"}); code != http.StatusOK {
+		t.Errorf("expected members to write, got %d", code)
+	}
+}
+
+func TestUserManagement(t *testing.T) {
+	cfg := setup(t, "alice", "bob", "carol")
+	utils.SetJwtKeys("access secret", "refresh secret")
+	utils.SetRevocationChecker(db.CheckTokenRevoked)
+
+	if err := db.SignupUser("root", "password", utils.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := login(t, "root").Token
+
+	if code := authed(t, adminOnly(FetchUsersHandler), login(t, "alice").Token, nil); code != http.StatusForbidden {
+		t.Errorf("expected members to be kept from managing users, got %d", code)
+	}
+
+	w := serve(CreateUserHandler, "root", jsonReq(t, userCreateReq{Username: "dave", Password: "plum-Tree-42", Role: utils.RoleGuest}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create user: %d %s", w.Code, w.Body)
+	}
+
+	if w = serve(CreateUserHandler, "root", jsonReq(t, userCreateReq{Username: "dave", Password: "plum-Tree-42"})); w.Code != http.StatusConflict {
+		t.Errorf("expected taken username to conflict, got %d", w.Code)
+	}
+
+	// Usernames name directories in storage
+	if w = serve(CreateUserHandler, "root", jsonReq(t, userCreateReq{Username: "x/../bob", Password: "plum-Tree-42"})); w.Code != http.StatusBadRequest {
+		t.Errorf("expected path-like username to be rejected, got %d", w.Code)
+	}
+
+	// Disabled users are logged out and can't log back in
+	carol := login(t, "carol")
+
+	if w = serve(SetUserDisabledHandler, "root", jsonReq(t, userDisableReq{Username: "carol", Disabled: true})); w.Code != http.StatusOK {
+		t.Fatalf("failed to disable user: %d %s", w.Code, w.Body)
+	}
+
+	if _, err := utils.ValidateToken(carol.Token); err == nil {
+		t.Error("expected disabled user's token to be revoked")
+	}
+
+	w = httptest.NewRecorder()
+	LoginHandler(w, jsonReq(t, loginReq{Username: "carol", Password: "password"}))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected disabled user to be kept from logging in, got %d", w.Code)
+	}
+
+	serve(SetUserDisabledHandler, "root", jsonReq(t, userDisableReq{Username: "carol", Disabled: false}))
+	login(t, "carol")
+
+	// There must always be an admin left
+	if w = serve(SetUserRoleHandler, "root", jsonReq(t, userRoleReq{Username: "root", Role: utils.RoleMember})); w.Code != http.StatusConflict {
+		t.Errorf("expected demoting the last admin to conflict, got %d", w.Code)
+	}
+
+	if w = serve(SetUserRoleHandler, "root", jsonReq(t, userRoleReq{Username: "carol", Role: utils.RoleAdmin})); w.Code != http.StatusOK {
+		t.Fatalf("failed to change role: %d %s", w.Code, w.Body)
+	}
+
+	if role, _ := db.GetUserRole("carol"); role != utils.RoleAdmin {
+		t.Errorf("expected carol to be an admin, got %q", role)
+	}
+
+	// Transferring notes needs a folder when their names clash
+	serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "todo", Content: "see [[ideas]]"}))
+	serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "ideas", Content: "# Ideas"}))
+	serve(CreateNote(cfg), "bob", jsonReq(t, noteCreateReq{NoteName: "todo", Content: "bob's"}))
+	if err := db.AddNoteTags("alice", "ideas.md", []string{"work"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w = serve(DeleteUser(cfg), "root", jsonReq(t, userDeleteReq{Username: "alice", TransferTo: "bob"})); w.Code != http.StatusConflict {
+		t.Fatalf("expected clashing note names to conflict, got %d", w.Code)
+	}
+
+	w = serve(DeleteUser(cfg), "root", jsonReq(t, userDeleteReq{Username: "alice", TransferTo: "bob", Folder: "alice"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to delete user: %d %s", w.Code, w.Body)
+	}
+
+	content, err := storage.Store.Read("bob", "alice/todo.md")
+	if err != nil || string(content) != "see [[ideas]]" {
+		t.Errorf("expected note to be transferred, got %q %v", content, err)
+	}
+
+	tags, err := db.GetUserTags("bob")
+	if err != nil || len(tags) != 1 || tags[0].Name != "work" {
+		t.Errorf("expected tags to be transferred, got %+v %v", tags, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.App.NoteDirectory, "alice")); !os.IsNotExist(err) {
+		t.Errorf("expected deleted user's directory to be removed, got %v", err)
+	}
+
+	// Without anyone to transfer to, the notes go too
+	serve(CreateNote(cfg), "carol", jsonReq(t, noteCreateReq{NoteName: "draft", Content: "x"}))
+
+	if w = serve(DeleteUser(cfg), "root", jsonReq(t, userDeleteReq{Username: "carol"})); w.Code != http.StatusOK {
+		t.Fatalf("failed to delete user: %d %s", w.Code, w.Body)
+	}
+
+	users, _ := db.GetUsers()
+	if len(users) != 3 {
+		t.Errorf("expected bob, dave and root to be left, got %+v", users)
+	}
+
+	if code := authed(t, adminOnly(FetchUsersHandler), admin, nil); code != http.StatusOK {
+		t.Errorf("expected admin to list users, got %d", code)
+	}
+
+	// Signing up can be left to admins
+	cfg.Auth.SignupMode = signupDisabled
+
+	w = httptest.NewRecorder()
+	SignupHandler(cfg)(w, jsonReq(t, loginReq{Username: "eve", Password: "plum-Tree-42"}))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected signup to be disabled, got %d", w.Code)
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	cfg := setup(t)
+	utils.SetJwtKeys("access secret", "refresh secret")
+
+	cfg.Auth.SignupMode = signupInvite
+	cfg.Auth.InvitationURL = "https://notes.example.com/signup"
+
+	signup := func(invitation string) (authResp, int) {
+		w := httptest.NewRecorder()
+		SignupHandler(cfg)(w, jsonReq(t, signupReq{Username: "gina", Password: "plum-Tree-42", Invitation: invitation}))
+
+		var resp authResp
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp, w.Code
+	}
+
+	if _, code := signup(""); code != http.StatusForbidden {
+		t.Errorf("expected signing up without an invitation to be refused, got %d", code)
+	}
+
+	w := serve(CreateInvitation(cfg), "root", jsonReq(t, invitationCreateReq{Role: utils.RoleGuest}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create invitation: %d %s", w.Code, w.Body)
+	}
+
+	var inv invitationCreateResp
+	json.NewDecoder(w.Body).Decode(&inv)
+
+	if !strings.HasSuffix(inv.Link, "?invite="+inv.Code) {
+		t.Errorf("expected a link carrying the code, got %q", inv.Link)
+	}
+
+	if _, code := signup("not-a-code"); code != http.StatusForbidden {
+		t.Errorf("expected unknown invitation to be refused, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	SignupHandler(cfg)(w, jsonReq(t, signupReq{Username: "../gina", Password: "plum-Tree-42", Invitation: inv.Code}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected path-like username to be rejected, got %d", w.Code)
+	}
+
+	resp, code := signup(inv.Code)
+	if code != http.StatusOK || resp.Role != utils.RoleGuest {
+		t.Fatalf("expected to sign up as a guest, got %d %+v", code, resp)
+	}
+
+	// Invitations work once
+	if _, code = signup(inv.Code); code != http.StatusForbidden {
+		t.Errorf("expected used invitation to be refused, got %d", code)
+	}
+
+	w = serve(CreateInvitation(cfg), "root", jsonReq(t, invitationCreateReq{}))
+	json.NewDecoder(w.Body).Decode(&inv)
+
+	if inv.Role != utils.RoleMember {
+		t.Errorf("expected invitations to default to members, got %q", inv.Role)
+	}
+
+	if w = serve(DeleteInvitationHandler, "root", jsonReq(t, invitationDeleteReq{Id: inv.Id})); w.Code != http.StatusOK {
+		t.Errorf("failed to delete invitation: %d %s", w.Code, w.Body)
+	}
+
+	invitations, err := db.GetInvitations()
+	if err != nil || len(invitations) != 0 {
+		t.Errorf("expected no outstanding invitations, got %+v %v", invitations, err)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/storage"
+)
+
+// Held while a note's revision is checked and its content replaced, so that
+// two conditional writes can't both see the same revision. Notes share a
+// fixed set of locks rather than each getting its own, so nothing has to be
+// cleaned up after them; holding more than one at a time could deadlock.
+var noteLocks [256]sync.Mutex
+
+func lockNote(owner, notename string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(owner + "/" + notename))
+
+	mu := &noteLocks[h.Sum32()%uint32(len(noteLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// A note's revision is the hash of its content, as for its versions
+func noteETag(content []byte) string {
+	return `"` + db.HashContent(content) + `"`
+}
+
+// Reports whether an If-Match or If-None-Match header lists `etag`. Only
+// If-None-Match compares weakly; for If-Match, weak validators never match.
+func etagListed(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Honours If-Match on a request that's about to change a note. Writes a 412
+// (or an error) and reports false if the note isn't at the expected revision.
+// Callers should hold the note's lock.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, owner, notename string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	content, err := storage.Store.Read(owner, notename)
+	if errors.Is(err, storage.ErrNotExist) {
+		http.Error(w, "note has changed", http.StatusPreconditionFailed)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "failed to read note", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to read note")
+		return false
+	}
+
+	if !etagListed(header, noteETag(content), false) {
+		w.Header().Set("ETag", noteETag(content))
+		http.Error(w, "note has changed", http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
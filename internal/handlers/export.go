@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const (
+	exportVersion      = "1"
+	exportManifestName = "manifest.json"
+	exportNotesDir     = "notes"
+	exportAttachDir    = "attachments"
+)
+
+type exportReq struct {
+	Folder string `json:"folder"` // e.g. "projects/musannif"; everything if empty
+}
+
+func writeZipFile(zw *zip.Writer, name string, modified time.Time, content []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+
+	_, err = f.Write(content)
+	if err != nil {
+		return fmt.Errorf("failed to write %s to archive: %w", name, err)
+	}
+
+	return nil
+}
+
+func unixTime(s string) time.Time {
+	t, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+
+	return time.Unix(t, 0)
+}
+
+// Writes the notes in `notes`, their attachments and a manifest describing
+// them to `w` as a zip archive. Notes whose content is missing from storage are
+// left out of both.
+func writeExport(w io.Writer, username, folder string, notes []utils.NoteMetadata) error {
+	zw := zip.NewWriter(w)
+
+	manifest := utils.ExportManifest{
+		Version:    exportVersion,
+		Owner:      username,
+		Folder:     folder,
+		ExportedAt: strconv.FormatInt(time.Now().Unix(), 10),
+		Notes:      []utils.ExportedNote{},
+	}
+
+	for _, note := range notes {
+		content, err := storage.Store.Read(username, note.Name)
+		if errors.Is(err, storage.ErrNotExist) {
+			logger.Log.Warn().Msgf("skipping export of %s/%s, which is missing from storage", username, note.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		exported := utils.ExportedNote{
+			NoteMetadata: note,
+			Path:         path.Join(exportNotesDir, note.Name),
+			Attachments:  []utils.ExportedAttachment{},
+		}
+
+		err = writeZipFile(zw, exported.Path, unixTime(note.LastModified), content)
+		if err != nil {
+			return err
+		}
+
+		attachments, err := db.GetNoteAttachments(username, note.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range attachments {
+			content, err := storage.Store.Read(username, storage.AttachmentName(a.Id))
+			if err != nil {
+				return fmt.Errorf("failed to read attachment %s: %w", a.Id, err)
+			}
+
+			ea := utils.ExportedAttachment{
+				Attachment: a,
+				Path:       path.Join(exportAttachDir, a.Id, a.Filename),
+			}
+
+			err = writeZipFile(zw, ea.Path, unixTime(a.CreatedAt), content)
+			if err != nil {
+				return err
+			}
+
+			exported.Attachments = append(exported.Attachments, ea)
+		}
+
+		manifest.Notes = append(manifest.Notes, exported)
+	}
+
+	// Written last so it only lists what actually made it into the archive
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	err = writeZipFile(zw, exportManifestName, time.Now(), b)
+	if err != nil {
+		return err
+	}
+
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	return nil
+}
+
+// Streams a zip of the user's notes (or those under a folder), their
+// attachments and a manifest of their metadata. The body is optional.
+func ExportNotes(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req exportReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		folder := strings.Trim(req.Folder, "/")
+
+		notes, err := db.GetUserNoteMetadata(username)
+		if err != nil {
+			http.Error(w, "failed to get metadata of user's notes", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get metadata of user's notes")
+			return
+		}
+
+		if folder != "" {
+			inFolder := []utils.NoteMetadata{}
+			for _, note := range notes {
+				if strings.HasPrefix(note.Name, folder+"/") {
+					inFolder = append(inFolder, note)
+				}
+			}
+			notes = inFolder
+		}
+
+		filename := username
+		if folder != "" {
+			filename += "-" + strings.ReplaceAll(folder, "/", "-")
+		}
+		filename += "-" + time.Now().Format("20060102") + ".zip"
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+		// The status is already sent by the time anything can go wrong, so all
+		// that's left to do is cut the archive short
+		err = writeExport(w, username, folder, notes)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("failed to export notes")
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
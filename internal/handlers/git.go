@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/gitstore"
+	"github.com/musannif-md/musannif/internal/logger"
+)
+
+// username -> *gitstore.Repo
+var noteRepos sync.Map
+
+func openNoteRepo(cfg *config.AppConfig, owner string) (*gitstore.Repo, error) {
+	if repo, ok := noteRepos.Load(owner); ok {
+		return repo.(*gitstore.Repo), nil
+	}
+
+	remote := ""
+	if cfg.Git.RemoteDirectory != "" {
+		remote = filepath.Join(cfg.Git.RemoteDirectory, owner+".git")
+	}
+
+	repo, err := gitstore.Open(filepath.Join(cfg.App.NoteDirectory, owner), remote)
+	if err != nil {
+		return nil, err
+	}
+
+	noteRepos.Store(owner, repo)
+	return repo, nil
+}
+
+// Commits changes to `notenames` in the owner's repository if git storage is
+// enabled. The change has already been persisted by then, so failures are
+// only logged.
+func commitNotes(cfg *config.AppConfig, owner, author, message string, notenames ...string) {
+	if !cfg.Git.Enabled {
+		return
+	}
+
+	repo, err := openNoteRepo(cfg, owner)
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to open note repository of %s", owner)
+		return
+	}
+
+	err = repo.Commit(author, message, notenames...)
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to commit to note repository of %s", owner)
+	}
+}
+
+func FetchNoteHistory(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.Git.Enabled {
+			http.Error(w, "git storage is not enabled", http.StatusNotFound)
+			return
+		}
+
+		var req noteCreateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		repo, err := openNoteRepo(cfg, username)
+		if err != nil {
+			http.Error(w, "failed to open note repository", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to open note repository")
+			return
+		}
+
+		commits, err := repo.Log(req.NoteName)
+		if err != nil {
+			http.Error(w, "failed to get note history", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get note history")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(commits)
+	}
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/indexer"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxImportSize int64 = 100 << 20
+
+	importCreated  = "created"
+	importConflict = "conflict"
+	importSkipped  = "skipped"
+	importFailed   = "failed"
+)
+
+func maxImportSize(cfg *config.AppConfig) int64 {
+	if cfg.App.MaxImportSize > 0 {
+		return cfg.App.MaxImportSize
+	}
+
+	return defaultMaxImportSize
+}
+
+type importedAttachment struct {
+	utils.Attachment
+	noteId  int64
+	content []byte
+}
+
+// Everything known about an archive being imported
+type importer struct {
+	cfg      *config.AppConfig
+	username string
+	folder   string
+
+	manifest    *utils.ExportManifest // set for archives made by `ExportNotes`
+	notes       []utils.ArchiveFile
+	files       map[string][]byte   // non-note files, by path in the archive
+	byBase      map[string][]string // paths of non-note files, by file name
+	attachments map[string]*importedAttachment
+	rejected    map[string]string // non-note files that can't be attachments, and why
+	results     []utils.ImportResult
+}
+
+// Our own exports keep notes under `notes/` and describe them in a manifest
+func readExportManifest(files []utils.ArchiveFile) *utils.ExportManifest {
+	for _, f := range files {
+		if f.Name != exportManifestName {
+			continue
+		}
+
+		var manifest utils.ExportManifest
+		if err := json.Unmarshal(f.Content, &manifest); err != nil || manifest.Version == "" {
+			return nil
+		}
+
+		return &manifest
+	}
+
+	return nil
+}
+
+// Other tools also write markdown as .markdown, in any case
+func isMarkdownFile(name string) bool {
+	ext := path.Ext(name)
+	return strings.EqualFold(ext, ".md") || strings.EqualFold(ext, ".markdown")
+}
+
+// Notes always end up with a .md extension
+func (im *importer) noteName(archivePath string) string {
+	name := strings.TrimSuffix(archivePath, path.Ext(archivePath)) + ".md"
+	if im.manifest != nil {
+		name = strings.TrimPrefix(name, exportNotesDir+"/")
+	}
+
+	if im.folder != "" {
+		name = im.folder + "/" + name
+	}
+
+	return name
+}
+
+// Finds the file in the archive that a link in the note at `notePath` points
+// to. Relative paths are tried first, then (as Obsidian does) paths from the
+// root of the archive, and finally bare file names, if they're unambiguous.
+func (im *importer) resolve(notePath, target string) (string, bool) {
+	if im.manifest != nil {
+		if id, ok := strings.CutPrefix(target, "/attachment/"); ok {
+			for _, note := range im.manifest.Notes {
+				for _, a := range note.Attachments {
+					if a.Id == id {
+						return a.Path, true
+					}
+				}
+			}
+		}
+	}
+
+	if strings.Contains(target, "://") || strings.HasPrefix(target, "/") {
+		return "", false
+	}
+
+	candidates := []string{
+		path.Join(path.Dir(notePath), target),
+		path.Clean(target),
+	}
+
+	for _, c := range candidates {
+		if _, ok := im.files[c]; ok {
+			return c, true
+		}
+	}
+
+	if paths := im.byBase[path.Base(target)]; len(paths) == 1 {
+		return paths[0], true
+	}
+
+	return "", false
+}
+
+// Makes the file at `filePath` an attachment of the note with `noteId`,
+// unless it's one already. Reports the attachment, or nil if the file isn't
+// allowed as one.
+func (im *importer) attach(filePath string, noteId int64, noteName string) *importedAttachment {
+	if a, ok := im.attachments[filePath]; ok {
+		return a
+	}
+
+	if _, ok := im.rejected[filePath]; ok {
+		return nil
+	}
+
+	content := im.files[filePath]
+
+	if int64(len(content)) > maxAttachmentSize(im.cfg) {
+		im.rejected[filePath] = "attachment too large"
+		return nil
+	}
+
+	mimeType := http.DetectContentType(content)
+	if !slices.Contains(attachmentTypes(im.cfg), mimeType) {
+		im.rejected[filePath] = "attachment type not allowed: " + mimeType
+		return nil
+	}
+
+	a := &importedAttachment{
+		Attachment: utils.Attachment{
+			Id:       uuid.NewString(),
+			NoteName: noteName,
+			Filename: path.Base(filePath),
+			MimeType: mimeType,
+			Size:     strconv.Itoa(len(content)),
+		},
+		noteId:  noteId,
+		content: content,
+	}
+
+	im.attachments[filePath] = a
+	return a
+}
+
+func ImportNotes(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maxSize := maxImportSize(cfg)
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+
+		err := r.ParseMultipartForm(maxSize)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "archive too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file not provided", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "failed to read archive", http.StatusBadRequest)
+			return
+		}
+
+		files, err := utils.ReadArchive(content, maxSize)
+		if errors.Is(err, utils.ErrArchiveTooLarge) {
+			http.Error(w, "archive too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		im := &importer{
+			cfg:         cfg,
+			username:    r.Context().Value("username").(string),
+			folder:      strings.Trim(r.FormValue("folder"), "/"),
+			manifest:    readExportManifest(files),
+			files:       map[string][]byte{},
+			byBase:      map[string][]string{},
+			attachments: map[string]*importedAttachment{},
+			rejected:    map[string]string{},
+			results:     []utils.ImportResult{},
+		}
+
+		for _, f := range files {
+			switch {
+			case im.manifest != nil && f.Name == exportManifestName:
+			case isMarkdownFile(f.Name):
+				im.notes = append(im.notes, f)
+			default:
+				im.files[f.Name] = f.Content
+				im.byBase[path.Base(f.Name)] = append(im.byBase[path.Base(f.Name)], f.Name)
+			}
+		}
+
+		created, err := im.run()
+		if err != nil {
+			http.Error(w, "failed to import notes", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to import notes")
+			return
+		}
+
+		names := make([]string, 0, len(created))
+		for _, f := range created {
+			_, err = indexer.NoteSaved(im.username, im.username, f.Name, f.Content)
+			if err != nil {
+				logger.Log.Error().Err(err).Msgf("failed to process imported note %s", f.Name)
+			}
+
+			names = append(names, f.Name)
+		}
+
+		if len(names) > 0 {
+			commitNotes(cfg, im.username, im.username, "Import "+strconv.Itoa(len(names))+" notes", names...)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(im.results)
+	}
+}
+
+// Tags of an exported note, as listed in the manifest
+func (im *importer) manifestTags(notePath string) []string {
+	if im.manifest == nil {
+		return nil
+	}
+
+	for _, note := range im.manifest.Notes {
+		if note.Path != notePath {
+			continue
+		}
+
+		tags, err := normalizeTags(note.Tags)
+		if err != nil {
+			return nil
+		}
+
+		return tags
+	}
+
+	return nil
+}
+
+// Creates the notes and attachments in the archive in a single transaction,
+// recording what happened to each file in `im.results`. Returns the notes that
+// were created, with their links to attachments rewritten. Nothing is created
+// if an error is returned.
+func (im *importer) run() (created []utils.ArchiveFile, err error) {
+	username := im.username
+
+	tx, err := db.BeginNoteTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		noteIds = map[string]int64{} // by path in the archive
+		staged  []*storage.Staged
+		stored  []utils.Attachment
+	)
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, s := range staged {
+			if err := s.Rollback(); err != nil {
+				logger.Log.Error().Err(err).Msg("failed to roll back imported note")
+			}
+		}
+		deleteAttachmentFiles(username, stored)
+	}()
+
+	// Links between notes whose extension changes must follow them
+	renamed := map[string]string{}
+	for _, f := range im.notes {
+		if ext := path.Ext(f.Name); ext != ".md" {
+			renamed[f.Name] = strings.TrimSuffix(f.Name, ext) + ".md"
+		}
+	}
+
+	for _, f := range im.notes {
+		name := im.noteName(f.Name)
+
+		id, err := tx.CreateNote(username, name)
+		if errors.Is(err, db.ErrConflict) {
+			im.results = append(im.results, utils.ImportResult{Path: f.Name, NoteName: name, Status: importConflict})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		noteIds[f.Name] = id
+
+		content := string(f.Content)
+		if len(renamed) > 0 {
+			content, _ = utils.RewriteRenamedLinks(f.Name, content, renamed)
+		}
+
+		content = utils.RewriteEmbeds(content, func(target string) (string, bool) {
+			filePath, ok := im.resolve(f.Name, target)
+			if !ok {
+				return "", false
+			}
+
+			a := im.attach(filePath, id, name)
+			if a == nil {
+				return "", false
+			}
+
+			return "/attachment/" + a.Id, true
+		})
+
+		s, err := storage.StageWrite(username, name, []byte(content))
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, s)
+
+		if tags := im.manifestTags(f.Name); len(tags) > 0 {
+			err = tx.AddNoteTags(username, id, tags)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		created = append(created, utils.ArchiveFile{Name: name, Content: []byte(content)})
+		im.results = append(im.results, utils.ImportResult{Path: f.Name, NoteName: name, Status: importCreated})
+	}
+
+	// Attachments of exported notes that their notes don't link to still
+	// belong to them
+	if im.manifest != nil {
+		for _, note := range im.manifest.Notes {
+			id, ok := noteIds[note.Path]
+			if !ok {
+				continue
+			}
+
+			for _, a := range note.Attachments {
+				if _, ok := im.files[a.Path]; ok {
+					im.attach(a.Path, id, im.noteName(note.Path))
+				}
+			}
+		}
+	}
+
+	filePaths := make([]string, 0, len(im.files))
+	for p := range im.files {
+		filePaths = append(filePaths, p)
+	}
+	slices.Sort(filePaths)
+
+	for _, p := range filePaths {
+		a, ok := im.attachments[p]
+		if !ok {
+			result := utils.ImportResult{Path: p, Status: importSkipped, Error: "not linked to from any imported note"}
+			if reason, rejected := im.rejected[p]; rejected {
+				result.Status, result.Error = importFailed, reason
+			}
+
+			im.results = append(im.results, result)
+			continue
+		}
+
+		err = storage.Store.Write(username, storage.AttachmentName(a.Id), a.content)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, a.Attachment)
+
+		err = tx.CreateAttachment(a.noteId, a.Id, a.Filename, a.MimeType, int64(len(a.content)))
+		if err != nil {
+			return nil, err
+		}
+
+		im.results = append(im.results, utils.ImportResult{
+			Path:         p,
+			NoteName:     a.NoteName,
+			AttachmentId: a.Id,
+			Status:       importCreated,
+		})
+	}
+
+	for _, s := range staged {
+		if err = s.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const defaultInvitationTTL = 7 * 24 * time.Hour
+
+type invitationCreateReq struct {
+	Role string `json:"role,omitempty"` // defaults to member
+}
+
+type invitationCreateResp struct {
+	utils.Invitation
+	Code string `json:"code"`           // only ever shown here
+	Link string `json:"link,omitempty"` // when `auth.invitation_url` is set
+}
+
+type invitationDeleteReq struct {
+	Id string `json:"invitation_id"`
+}
+
+// Lets an admin invite someone to sign up with a given role. The code is
+// returned for the admin to pass on, and works once.
+func CreateInvitation(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		var req invitationCreateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Role == "" {
+			req.Role = utils.RoleMember
+		}
+
+		if !utils.ValidRole(req.Role) {
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+
+		ttl := cfg.Auth.InvitationTTL
+		if ttl <= 0 {
+			ttl = defaultInvitationTTL
+		}
+
+		code, id, expiresAt, err := db.CreateInvitation(username, req.Role, ttl)
+		if err != nil {
+			http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to create invitation")
+			return
+		}
+
+		resp := invitationCreateResp{
+			Invitation: utils.Invitation{
+				Id:        strconv.FormatInt(id, 10),
+				Role:      req.Role,
+				CreatedBy: username,
+				CreatedAt: strconv.FormatInt(time.Now().Unix(), 10),
+				ExpiresAt: strconv.FormatInt(expiresAt.Unix(), 10),
+			},
+			Code: code,
+		}
+
+		if cfg.Auth.InvitationURL != "" {
+			resp.Link = cfg.Auth.InvitationURL + "?invite=" + url.QueryEscape(code)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Lists invitations that haven't been used or expired yet
+func FetchInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := db.GetInvitations()
+	if err != nil {
+		http.Error(w, "Failed to get invitations", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get invitations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func DeleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req invitationDeleteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseInt(req.Id, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invitation id", http.StatusBadRequest)
+		return
+	}
+
+	err = db.DeleteInvitation(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete invitation", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to delete invitation")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
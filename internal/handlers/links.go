@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/indexer"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+type noteRenameResp struct {
+	IncomingLinks []string `json:"incoming_links"` // notes that linked to the old name
+	Rewritten     []string `json:"rewritten"`      // those of them now linking to the new name
+}
+
+// Points the links in `sources` at `newName` instead of `oldName`. Notes that
+// fail to be rewritten are logged and skipped; the rename has already happened.
+func rewriteIncomingLinks(username, oldName, newName string, sources []string) []string {
+	rewritten := []string{}
+
+	for _, source := range sources {
+		if rewriteLinksIn(username, source, oldName, newName) {
+			rewritten = append(rewritten, source)
+		}
+	}
+
+	return rewritten
+}
+
+// Holds the note's lock, so that an edit made at the same time isn't lost
+func rewriteLinksIn(username, source, oldName, newName string) bool {
+	unlock := lockNote(username, source)
+	defer unlock()
+
+	content, err := storage.Store.Read(username, source)
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to read %s to rewrite its links", source)
+		return false
+	}
+
+	updated, changed := utils.RewriteLinks(source, string(content), oldName, newName)
+	if !changed {
+		return false
+	}
+
+	err = storage.Store.Write(username, source, []byte(updated))
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to write %s with rewritten links", source)
+		return false
+	}
+
+	_, err = indexer.NoteSaved(username, username, source, []byte(updated))
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to process %s with rewritten links", source)
+	}
+
+	return true
+}
+
+// Relative markdown links in a renamed note may resolve differently from its
+// new location, so its links have to be parsed again
+func reindexNoteLinks(username, notename string) {
+	content, err := storage.Store.Read(username, notename)
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to read %s to reindex its links", notename)
+		return
+	}
+
+	err = db.SetNoteLinks(username, notename, utils.ParseLinks(notename, string(content)))
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to reindex links of %s", notename)
+	}
+}
+
+// The note itself needn't exist, which lists what links to a note that's yet
+// to be written
+func FetchBacklinks(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteCreateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		backlinks, err := db.GetBacklinks(username, req.NoteName)
+		if err != nil {
+			http.Error(w, "failed to get backlinks", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get backlinks")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backlinks)
+	}
+}
+
+func FetchBrokenLinks(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		links, err := db.GetBrokenLinks(username)
+		if err != nil {
+			http.Error(w, "failed to get broken links", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get broken links")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+	}
+}
+
+func FetchLinkGraph(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		graph, err := db.GetLinkGraph(username)
+		if err != nil {
+			http.Error(w, "failed to get link graph", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get link graph")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(graph)
+	}
+}
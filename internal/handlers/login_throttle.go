@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/throttle"
+)
+
+const defaultLoginAttemptsLimit = 100
+
+var (
+	// Generous, since many users may share an address; only ever slows down
+	ipThrottleOpts = throttle.Options{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		Window:       time.Hour,
+	}
+
+	// Keyed on the account along with the address, so that failing from one
+	// address can't lock the account's owner out from another
+	clientThrottleOpts = throttle.Options{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     30 * time.Second,
+		LockoutAfter: 10,
+		LockoutFor:   15 * time.Minute,
+		Window:       time.Hour,
+	}
+
+	// Slows down guessing one account's password from many addresses, but
+	// only by a little and never locks out, since anyone can fail as anyone
+	accountThrottleOpts = throttle.Options{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Second,
+		Window:       time.Hour,
+	}
+
+	ipThrottle      = throttle.New(ipThrottleOpts)
+	clientThrottle  = throttle.New(clientThrottleOpts)
+	accountThrottle = throttle.New(accountThrottleOpts)
+)
+
+type loginAttemptsReq struct {
+	Username string `json:"username,omitempty"` // every user's if empty
+	Limit    string `json:"limit,omitempty"`
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func clientKey(r *http.Request, username string) string {
+	return username + "@" + clientIP(r)
+}
+
+// Writes a 429 if the client, or the account (when `username` isn't empty),
+// has failed too often recently. The response is the same whether or not the
+// account exists.
+func loginThrottled(w http.ResponseWriter, r *http.Request, username string) bool {
+	now := time.Now()
+
+	wait := ipThrottle.Wait(clientIP(r), now)
+	if username != "" {
+		wait = max(wait, clientThrottle.Wait(clientKey(r, username), now), accountThrottle.Wait(username, now))
+	}
+
+	if wait == 0 {
+		return false
+	}
+
+	logger.Log.Warn().Str("username", username).Str("ip", clientIP(r)).Msg("throttled login attempt")
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed attempts; try again later", http.StatusTooManyRequests)
+	return true
+}
+
+// Counts a failed attempt against the client and the account, and records it
+func loginFailed(r *http.Request, username, reason string) {
+	now := time.Now()
+
+	ipThrottle.Fail(clientIP(r), now)
+	if username != "" {
+		clientThrottle.Fail(clientKey(r, username), now)
+		accountThrottle.Fail(username, now)
+	}
+
+	err := db.RecordFailedLogin(username, clientIP(r), reason)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to record failed login")
+	}
+}
+
+// Clears the account's failures once it's been fully logged into
+func loginSucceeded(r *http.Request, username string) {
+	clientThrottle.Reset(clientKey(r, username))
+	accountThrottle.Reset(username)
+}
+
+// Lets admins look through failed logins
+func FetchLoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	var req loginAttemptsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultLoginAttemptsLimit
+	if req.Limit != "" {
+		var err error
+
+		limit, err = strconv.Atoi(req.Limit)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	attempts, err := db.GetFailedLogins(req.Username, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch login attempts", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get login attempts")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
@@ -2,19 +2,45 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/musannif-md/musannif/internal/config"
 	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/indexer"
 	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/publish"
+	"github.com/musannif-md/musannif/internal/resolver"
+	"github.com/musannif-md/musannif/internal/storage"
 )
 
 type noteCreateReq struct {
+	NoteName   string `json:"note_name"`
+	Content    string `json:"content"`
+	TemplateId string `json:"template_id,omitempty"` // when creating, instead of content
+}
+
+type noteRenameReq struct {
 	NoteName string `json:"note_name"`
-	Content  string `json:"content"`
+	NewName  string `json:"new_name"`
+
+	// Whether notes linking to the old name should be changed to link to the
+	// new one; otherwise they're only listed in the response
+	RewriteLinks bool `json:"rewrite_links"`
+}
+
+type noteListReq struct {
+	Properties map[string]string `json:"properties"` // property -> value the note must have (or, for lists, contain)
+	SortBy     string            `json:"sort_by"`    // property to sort by
+	Order      string            `json:"order"`      // "asc" (default) or "desc"
+
+	Tags        []string `json:"tags"`         // notes must have all of these
+	AnyTags     []string `json:"any_tags"`     // notes must have at least one of these
+	ExcludeTags []string `json:"exclude_tags"` // notes must have none of these
 }
 
 type noteCreationResp struct {
@@ -22,7 +48,123 @@ type noteCreationResp struct {
 }
 
 type noteContent struct {
-	Content string `json:"content"`
+	Content  string `json:"content"`
+	Revision string `json:"revision"` // same as the ETag header, unquoted
+}
+
+// Picks up notes written to directly in the note directory, by anything but
+// the server itself. Content the server wrote is recognised by being identical
+// to the latest version.
+func IngestExternalEdit(cfg *config.AppConfig) func(owner, notename string, content []byte) {
+	return func(owner, notename string, _ []byte) {
+		unlock := lockNote(owner, notename)
+		defer unlock()
+
+		// The watcher's copy may predate a save made through the server
+		content, err := storage.Store.Read(owner, notename)
+		if errors.Is(err, storage.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			logger.Log.Error().Err(err).Msgf("failed to read external file %s/%s", owner, notename)
+			return
+		}
+
+		_, err = db.CreateNote(owner, notename)
+		if err != nil && !errors.Is(err, db.ErrConflict) {
+			logger.Log.Error().Err(err).Msgf("failed to create note for external file %s/%s", owner, notename)
+			return
+		}
+
+		changed, err := indexer.NoteSaved(owner, owner, notename, content)
+		if err != nil {
+			logger.Log.Error().Err(err).Msgf("failed to process external edit of %s/%s", owner, notename)
+			return
+		}
+
+		if !changed {
+			return
+		}
+
+		logger.Log.Info().Msgf("ingested external edit of %s/%s", owner, notename)
+
+		commitNotes(cfg, owner, owner, "Edit "+notename+" outside of musannif", notename)
+		resolver.OnExternalChange(owner, notename, content)
+	}
+}
+
+// Drops a note whose file was deleted or moved away outside of the server,
+// closing the sessions editing it. Gone folders take every note under them.
+func IngestExternalRemoval(cfg *config.AppConfig) func(owner, name string) {
+	return func(owner, name string) {
+		if strings.HasSuffix(name, ".md") {
+			dropRemovedNote(cfg, owner, name)
+			return
+		}
+
+		notes, err := db.GetUserNoteMetadata(owner)
+		if errors.Is(err, db.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			logger.Log.Error().Err(err).Msgf("failed to get notes under removed folder %s/%s", owner, name)
+			return
+		}
+
+		for _, n := range notes {
+			if strings.HasPrefix(n.Name, name+"/") {
+				dropRemovedNote(cfg, owner, n.Name)
+			}
+		}
+	}
+}
+
+func dropRemovedNote(cfg *config.AppConfig, owner, notename string) {
+	unlock := lockNote(owner, notename)
+	defer unlock()
+
+	// Deleted or renamed through the server, which holds the lock while
+	// doing so, or put back in the meantime
+	_, err := storage.Store.Read(owner, notename)
+	if !errors.Is(err, storage.ErrNotExist) {
+		if err != nil {
+			logger.Log.Error().Err(err).Msgf("failed to read removed note %s/%s", owner, notename)
+		}
+		return
+	}
+
+	attachments, err := db.GetNoteAttachments(owner, notename)
+	if errors.Is(err, db.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to get attachments of removed note %s/%s", owner, notename)
+		return
+	}
+
+	tx, err := db.BeginNoteTx()
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to delete removed note %s/%s", owner, notename)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.DeleteNote(owner, notename)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to delete removed note %s/%s", owner, notename)
+		return
+	}
+
+	logger.Log.Info().Msgf("ingested external removal of %s/%s", owner, notename)
+
+	deleteAttachmentFiles(owner, attachments)
+
+	commitNotes(cfg, owner, owner, "Delete "+notename+" outside of musannif", notename)
+	publish.NotesChanged(owner, notename)
+	resolver.CloseNoteSessions(owner, notename)
 }
 
 func CreateNote(cfg *config.AppConfig) http.HandlerFunc {
@@ -41,44 +183,71 @@ func CreateNote(cfg *config.AppConfig) http.HandlerFunc {
 
 		username := r.Context().Value("username").(string)
 
-		// Make directories
-		notesDirPath := filepath.Join(cfg.App.NoteDirectory, username)
-		err = os.MkdirAll(notesDirPath, os.ModePerm)
+		req.NoteName += ".md"
+
+		if req.TemplateId != "" {
+			if req.Content != "" {
+				http.Error(w, "either content or a template id can be provided, not both", http.StatusBadRequest)
+				return
+			}
+
+			var ok bool
+			req.Content, ok = instantiateTemplate(w, username, req.NoteName, req.TemplateId)
+			if !ok {
+				return
+			}
+		}
+
+		tx, err := db.BeginNoteTx()
 		if err != nil {
-			http.Error(w, "error initializing note directory: %v\n", http.StatusInternalServerError)
-			logger.Log.Error().Err(err).Msgf("error initializing note directory: %s", notesDirPath)
+			http.Error(w, "failed to create note in DB", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to create note in DB")
 			return
 		}
+		defer tx.Rollback()
 
-		req.NoteName += ".md"
-		path := filepath.Join(notesDirPath, req.NoteName)
+		// Overwriting goes through `UpdateNote` so that it's versioned
+		id, err := tx.CreateNote(username, req.NoteName)
+		if errors.Is(err, db.ErrConflict) {
+			http.Error(w, "note already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to create note in DB", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to create note in DB")
+			return
+		}
 
-		// Create file
-		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		// The file only appears once the row is certain to be inserted, and is
+		// removed again if that doesn't work out
+		staged, err := storage.StageWrite(username, req.NoteName, []byte(req.Content))
 		if err != nil {
 			http.Error(w, "failed to create note file", http.StatusInternalServerError)
 			logger.Log.Error().Err(err).Msg("failed to create note file")
 			return
 		}
 
-		// Write contents
-		if req.Content != "" {
-			_, err = f.WriteString(req.Content)
-			if err != nil {
-				http.Error(w, "failed to copy over contents", http.StatusInternalServerError)
-				logger.Log.Error().Err(err).Msg("failed to copy over contents")
-				return
+		err = staged.Commit()
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			http.Error(w, "failed to create note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to create note")
+
+			if err := staged.Rollback(); err != nil {
+				logger.Log.Error().Err(err).Msg("failed to roll back note file")
 			}
+			return
 		}
 
-		// Insert file info in DB
-		id, err := db.CreateNote(username, req.NoteName)
+		_, err = indexer.NoteSaved(username, username, req.NoteName, []byte(req.Content))
 		if err != nil {
-			http.Error(w, "failed to create note in DB", http.StatusInternalServerError)
-			logger.Log.Error().Err(err).Msg("failed to create note in DB")
-			return
+			logger.Log.Error().Err(err).Msg("failed to process saved note")
 		}
 
+		commitNotes(cfg, username, username, "Create "+req.NoteName, req.NoteName)
+
 		// Construct and send response
 		data := noteCreationResp{
 			NoteId: strconv.FormatInt(id, 10),
@@ -89,7 +258,7 @@ func CreateNote(cfg *config.AppConfig) http.HandlerFunc {
 	}
 }
 
-func DeleteNote(cfg *config.AppConfig) http.HandlerFunc {
+func UpdateNote(cfg *config.AppConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req noteCreateReq
 		err := json.NewDecoder(r.Body).Decode(&req)
@@ -103,33 +272,208 @@ func DeleteNote(cfg *config.AppConfig) http.HandlerFunc {
 			return
 		}
 
-		/*
-			CHECK: how could we ensure consistency b/w the filesystem and DB?
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		_, err = db.GetNoteId(username, req.NoteName)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		unlock := lockNote(username, req.NoteName)
+		defer unlock()
+
+		if !checkIfMatch(w, r, username, req.NoteName) {
+			return
+		}
+
+		err = storage.Store.Write(username, req.NoteName, []byte(req.Content))
+		if err != nil {
+			http.Error(w, "failed to write note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to write note")
+			return
+		}
+
+		_, err = indexer.NoteSaved(username, username, req.NoteName, []byte(req.Content))
+		if err != nil {
+			http.Error(w, "failed to process saved note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to process saved note")
+			return
+		}
+
+		commitNotes(cfg, username, username, "Update "+req.NoteName, req.NoteName)
+
+		w.Header().Set("ETag", noteETag([]byte(req.Content)))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func RenameNote(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteRenameReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 
-			One horrible idea is to spin up two threads with a conditional variable in
-			the database's function to rollback if the filesystem delete fails
-		*/
+		if req.NoteName == "" || req.NewName == "" {
+			http.Error(w, "note name or new name not provided", http.StatusBadRequest)
+			return
+		}
 
 		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+		req.NewName += ".md"
 
-		// Delete database entry
+		// Released before links are rewritten, since that locks each linking
+		// note and two notes may share a lock
+		unlock := sync.OnceFunc(lockNote(username, req.NoteName))
+		defer unlock()
+
+		if !checkIfMatch(w, r, username, req.NoteName) {
+			return
+		}
+
+		tx, err := db.BeginNoteTx()
+		if err != nil {
+			http.Error(w, "failed to rename note in DB", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to rename note in DB")
+			return
+		}
+		defer tx.Rollback()
+
+		err = tx.RenameNote(username, req.NoteName, req.NewName)
+		if errors.Is(err, db.ErrConflict) {
+			http.Error(w, "a note with the new name already exists", http.StatusConflict)
+			return
+		}
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		err = storage.Store.Rename(username, req.NoteName, req.NewName)
+		if err != nil {
+			http.Error(w, "failed to rename note file", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to rename note file")
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, "failed to rename note in DB", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to rename note in DB")
+
+			if err := storage.Store.Rename(username, req.NewName, req.NoteName); err != nil {
+				logger.Log.Error().Err(err).Msg("failed to roll back note file rename")
+			}
+			return
+		}
+
+		unlock()
+		reindexNoteLinks(username, req.NewName)
+
+		resp := noteRenameResp{Rewritten: []string{}}
+
+		resp.IncomingLinks, err = db.GetBacklinks(username, req.NoteName)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("failed to get backlinks of renamed note")
+			resp.IncomingLinks = []string{}
+		}
+
+		if req.RewriteLinks {
+			resp.Rewritten = rewriteIncomingLinks(username, req.NoteName, req.NewName, resp.IncomingLinks)
+		}
+
+		paths := append([]string{req.NoteName, req.NewName}, resp.Rewritten...)
+		commitNotes(cfg, username, username, "Rename "+req.NoteName+" to "+req.NewName, paths...)
+		publish.NotesChanged(username, paths...)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func DeleteNote(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteCreateReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
 		req.NoteName += ".md"
-		err = db.DeleteNote(username, req.NoteName)
+
+		// Their rows go along with the note's, but their content has to be
+		// removed separately
+		attachments, err := db.GetNoteAttachments(username, req.NoteName)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		unlock := lockNote(username, req.NoteName)
+		defer unlock()
+
+		if !checkIfMatch(w, r, username, req.NoteName) {
+			return
+		}
+
+		tx, err := db.BeginNoteTx()
 		if err != nil {
 			http.Error(w, "failed to delete note from DB", http.StatusInternalServerError)
 			logger.Log.Error().Err(err).Msg("failed to delete note from DB")
 			return
 		}
+		defer tx.Rollback()
 
-		// Delete file
-		path := filepath.Join(cfg.App.NoteDirectory, username, req.NoteName)
-		err = os.Remove(path)
-		if err != nil {
+		// Delete database entry
+		err = tx.DeleteNote(username, req.NoteName)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		// Move the file aside so it can be put back if the row can't be deleted.
+		// A missing file means there's nothing to put back.
+		staged, err := storage.StageDelete(username, req.NoteName)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
 			http.Error(w, "failed to delete note file", http.StatusInternalServerError)
 			logger.Log.Error().Err(err).Msg("failed to delete note file")
 			return
 		}
 
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, "failed to delete note from DB", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to delete note from DB")
+
+			if staged != nil {
+				if err := staged.Rollback(); err != nil {
+					logger.Log.Error().Err(err).Msg("failed to restore note file")
+				}
+			}
+			return
+		}
+
+		// The note is gone as far as anyone can tell; a failure here only leaves
+		// hidden staged content behind
+		if staged != nil {
+			if err := staged.Commit(); err != nil {
+				logger.Log.Error().Err(err).Msg("failed to delete staged note file")
+			}
+		}
+
+		deleteAttachmentFiles(username, attachments)
+
+		commitNotes(cfg, username, username, "Delete "+req.NoteName, req.NoteName)
+		publish.NotesChanged(username, req.NoteName)
+
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -150,19 +494,30 @@ func FetchNoteData(cfg *config.AppConfig) http.HandlerFunc {
 		username := r.Context().Value("username").(string)
 
 		req.NoteName += ".md"
-		path := filepath.Join(cfg.App.NoteDirectory, username, req.NoteName)
-
-		content, err := os.ReadFile(path)
+		content, err := storage.Store.Read(username, req.NoteName)
+		if errors.Is(err, storage.ErrNotExist) {
+			http.Error(w, "note not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, "failed to read note", http.StatusInternalServerError)
 			logger.Log.Error().Err(err).Msg("failed to read note")
 			return
 		}
 
+		etag := noteETag(content)
+		w.Header().Set("ETag", etag)
+
+		if etagListed(r.Header.Get("If-None-Match"), etag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		// TODO: base64 encode contents over first...
 
 		data := noteContent{
-			Content: string(content),
+			Content:  string(content),
+			Revision: strings.Trim(etag, `"`),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -172,6 +527,27 @@ func FetchNoteData(cfg *config.AppConfig) http.HandlerFunc {
 
 func FetchNoteList(cfg *config.AppConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// The body is optional; without one, every note is returned
+		var req noteListReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Order != "" && req.Order != "asc" && req.Order != "desc" {
+			http.Error(w, "order must be either 'asc' or 'desc'", http.StatusBadRequest)
+			return
+		}
+
+		for _, tags := range []*[]string{&req.Tags, &req.AnyTags, &req.ExcludeTags} {
+			*tags, err = normalizeTags(*tags)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		username := r.Context().Value("username").(string)
 
 		noteListMd, err := db.GetUserNoteMetadata(username)
@@ -181,6 +557,12 @@ func FetchNoteList(cfg *config.AppConfig) http.HandlerFunc {
 			return
 		}
 
+		noteListMd = filterNotes(noteListMd, req.Properties)
+		noteListMd = filterNotesByTags(noteListMd, req.Tags, req.AnyTags, req.ExcludeTags)
+		if req.SortBy != "" {
+			sortNotes(noteListMd, req.SortBy, req.Order == "desc")
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(noteListMd)
 	}
@@ -1 +1,598 @@
 package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/publish"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/throttle"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Sets up a test database and note storage with the given users
+func setup(t *testing.T, usernames ...string) *config.AppConfig {
+	t.Helper()
+
+	if err := db.InitTestDb(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.CleanupTestDb() })
+
+	cfg := &config.AppConfig{}
+	cfg.App.NoteDirectory = t.TempDir()
+	storage.Store = storage.NewFsStore(cfg.App.NoteDirectory)
+
+	cfg.App.PublicDirectory = t.TempDir()
+	publish.Initialize(cfg)
+	t.Cleanup(publish.Flush)
+
+	ipThrottle = throttle.New(ipThrottleOpts)
+	clientThrottle = throttle.New(clientThrottleOpts)
+	accountThrottle = throttle.New(accountThrottleOpts)
+
+	for _, u := range usernames {
+		if err := db.SignupUser(u, "password", "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return cfg
+}
+
+// Runs `h` as if `username` had been authenticated by `AuthMiddleware`
+func serve(h http.HandlerFunc, username string, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx := context.WithValue(r.Context(), "username", username)
+	h(w, r.WithContext(ctx))
+	return w
+}
+
+func jsonReq(t *testing.T, body any) *http.Request {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+}
+
+func TestAttachments(t *testing.T) {
+	cfg := setup(t, "alice", "bob")
+
+	w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "note", Content: "# Note"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+	}
+
+	upload := func(content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("note_name", "note")
+		fw, _ := mw.CreateFormFile("file", "image.png")
+		fw.Write(content)
+		mw.Close()
+
+		r := httptest.NewRequest(http.MethodPost, "/attachment", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		return serve(UploadAttachment(cfg), "alice", r)
+	}
+
+	// Sniffed type is what counts, regardless of the file name
+	if w = upload([]byte("<html><script></script></html>")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected html to be rejected, got %d", w.Code)
+	}
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 32)...)
+	w = upload(png)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to upload attachment: %d %s", w.Code, w.Body)
+	}
+
+	var a utils.Attachment
+	json.NewDecoder(w.Body).Decode(&a)
+	if a.MimeType != "image/png" || a.Filename != "image.png" {
+		t.Errorf("unexpected attachment: %+v", a)
+	}
+
+	download := func(username string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/attachment/"+a.Id, nil)
+		r.SetPathValue("id", a.Id)
+		return serve(DownloadAttachment(cfg), username, r)
+	}
+
+	if w = download("alice"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), png) {
+		t.Errorf("owner couldn't download attachment: %d", w.Code)
+	}
+
+	if w = download("bob"); w.Code != http.StatusNotFound {
+		t.Errorf("expected other users to get 404, got %d", w.Code)
+	}
+
+	w = serve(DeleteNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "note"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to delete note: %d %s", w.Code, w.Body)
+	}
+
+	if _, err := storage.Store.Read("alice", storage.AttachmentName(a.Id)); err != storage.ErrNotExist {
+		t.Errorf("expected attachment to be deleted along with its note, got %v", err)
+	}
+}
+
+func TestFetchNoteListByProperties(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	notes := map[string]string{
+		"a": "---\nstatus: draft\npriority: 10\ntags: [x, y]\n---\n",
+		"b": "---\nstatus: done\npriority: 9\n---\n",
+		"c": "---\nstatus: draft\npriority: 2\ntags: [y]\n---\n",
+		"d": "no front matter",
+	}
+
+	for name, content := range notes {
+		w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: name, Content: content}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+		}
+	}
+
+	list := func(req noteListReq) []string {
+		w := serve(FetchNoteList(cfg), "alice", jsonReq(t, req))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to list notes: %d %s", w.Code, w.Body)
+		}
+
+		var md []utils.NoteMetadata
+		json.NewDecoder(w.Body).Decode(&md)
+
+		names := []string{}
+		for _, n := range md {
+			names = append(names, n.Name)
+		}
+		return names
+	}
+
+	got := list(noteListReq{Properties: map[string]string{"status": "draft", "tags": "y"}, SortBy: "priority"})
+	if !slices.Equal(got, []string{"c.md", "a.md"}) {
+		t.Errorf("unexpected filtered notes: %v", got)
+	}
+
+	got = list(noteListReq{SortBy: "priority", Order: "desc"})
+	if !slices.Equal(got, []string{"a.md", "b.md", "c.md", "d.md"}) {
+		t.Errorf("unexpected sorted notes: %v", got)
+	}
+}
+
+func TestIngestExternalEdit(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	if err := storage.Store.Write("alice", "external.md", []byte("current")); err != nil {
+		t.Fatal(err)
+	}
+
+	// What's on disk wins over the watcher's (older) copy
+	IngestExternalEdit(cfg)("alice", "external.md", []byte("stale"))
+
+	versions, err := db.GetNoteVersions("alice", "external.md")
+	if err != nil || len(versions) != 1 || versions[0].Hash != db.HashContent([]byte("current")) {
+		t.Errorf("unexpected versions of external note: %+v (%v)", versions, err)
+	}
+}
+
+func TestIngestExternalRemoval(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	for _, name := range []string{"kept", "gone", "folder/a", "folder/b"} {
+		w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: name, Content: name}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+		}
+	}
+
+	for _, name := range []string{"gone.md", "folder/a.md", "folder/b.md"} {
+		if err := storage.Store.Delete("alice", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remove := IngestExternalRemoval(cfg)
+	remove("alice", "gone.md")
+	remove("alice", "folder")
+
+	// Still on disk, so it's left alone
+	remove("alice", "kept.md")
+
+	for name, exists := range map[string]bool{"kept.md": true, "gone.md": false, "folder/a.md": false, "folder/b.md": false} {
+		_, err := db.GetNoteId("alice", name)
+		if exists != (err == nil) {
+			t.Errorf("expected %s to exist: %v, got %v", name, exists, err)
+		}
+	}
+}
+
+func TestRenameNoteRewritesLinks(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	notes := map[string]string{
+		"target":     "# Target",
+		"wiki":       "See [[target#Intro|the target]].",
+		"dir/nested": "See [the target](../target.md) and [[missing]].",
+	}
+	for name, content := range notes {
+		w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: name, Content: content}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+		}
+	}
+
+	w := serve(FetchBrokenLinks(cfg), "alice", jsonReq(t, nil))
+	var broken []utils.BrokenLink
+	json.NewDecoder(w.Body).Decode(&broken)
+	if !slices.Equal(broken, []utils.BrokenLink{{Source: "dir/nested.md", Target: "missing.md"}}) {
+		t.Errorf("unexpected broken links: %+v", broken)
+	}
+
+	w = serve(RenameNote(cfg), "alice", jsonReq(t, noteRenameReq{NoteName: "target", NewName: "archive/renamed", RewriteLinks: true}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to rename note: %d %s", w.Code, w.Body)
+	}
+
+	var resp noteRenameResp
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !slices.Equal(resp.Rewritten, []string{"dir/nested.md", "wiki.md"}) {
+		t.Errorf("unexpected rewritten notes: %+v", resp)
+	}
+
+	for name, expected := range map[string]string{
+		"wiki.md":       "See [[archive/renamed#Intro|the target]].",
+		"dir/nested.md": "See [the target](../archive/renamed.md) and [[missing]].",
+	} {
+		content, _ := storage.Store.Read("alice", name)
+		if string(content) != expected {
+			t.Errorf("unexpected content of %s: %q", name, content)
+		}
+	}
+
+	w = serve(FetchBacklinks(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "archive/renamed"}))
+	var backlinks []string
+	json.NewDecoder(w.Body).Decode(&backlinks)
+	if !slices.Equal(backlinks, []string{"dir/nested.md", "wiki.md"}) {
+		t.Errorf("unexpected backlinks: %v", backlinks)
+	}
+}
+
+func TestExportNotes(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	for _, name := range []string{"top", "projects/one", "projects/two"} {
+		w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: name, Content: "# " + name}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+		}
+	}
+
+	w := serve(ExportNotes(cfg), "alice", jsonReq(t, exportReq{Folder: "projects"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to export notes: %d %s", w.Code, w.Body)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{}
+	for _, f := range zr.File {
+		files = append(files, f.Name)
+	}
+
+	if !slices.Equal(files, []string{"notes/projects/one.md", "notes/projects/two.md", "manifest.json"}) {
+		t.Errorf("unexpected files in export: %v", files)
+	}
+
+	f, err := zr.Open("manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var manifest utils.ExportManifest
+	if err = json.NewDecoder(f).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.Owner != "alice" || len(manifest.Notes) != 2 || manifest.Notes[0].Id == "" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+}
+
+func TestImportNotes(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "imported/Existing", Content: "# Existing"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+	}
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 32)...)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, f := range []struct{ name, content string }{
+		{"Existing.md", "# Clashes with an existing note"},
+		{"daily/Today.md", "![[diagram.png]] and ![again](../assets/diagram.png), then [tomorrow](Tomorrow.MARKDOWN)"},
+		{"daily/Tomorrow.MARKDOWN", "# Tomorrow"},
+		{"assets/diagram.png", string(png)},
+		{"assets/unused.png", string(png)},
+		{".obsidian/workspace.json", "{}"},
+	} {
+		fw, _ := zw.Create(f.name)
+		fw.Write([]byte(f.content))
+	}
+	zw.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("folder", "imported")
+	fw, _ := mw.CreateFormFile("file", "vault.zip")
+	fw.Write(archive.Bytes())
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	w = serve(ImportNotes(cfg), "alice", r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to import notes: %d %s", w.Code, w.Body)
+	}
+
+	var results []utils.ImportResult
+	json.NewDecoder(w.Body).Decode(&results)
+
+	statuses := map[string]string{}
+	attachmentId := ""
+	for _, res := range results {
+		statuses[res.Path] = res.Status
+		if res.Path == "assets/diagram.png" {
+			attachmentId = res.AttachmentId
+		}
+	}
+
+	expected := map[string]string{
+		"Existing.md":             importConflict,
+		"daily/Today.md":          importCreated,
+		"daily/Tomorrow.MARKDOWN": importCreated,
+		"assets/diagram.png":      importCreated,
+		"assets/unused.png":       importSkipped,
+	}
+	if len(statuses) != len(expected) {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	for p, status := range expected {
+		if statuses[p] != status {
+			t.Errorf("expected %s to be %s, got %q", p, status, statuses[p])
+		}
+	}
+
+	content, err := storage.Store.Read("alice", "imported/daily/Today.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Store.Read("alice", "imported/daily/Tomorrow.md"); err != nil {
+		t.Errorf("expected .markdown notes to be imported as .md, got %v", err)
+	}
+
+	link := "/attachment/" + attachmentId
+	if string(content) != "![diagram.png]("+link+") and ![again]("+link+"), then [tomorrow](Tomorrow.md)" {
+		t.Errorf("unexpected imported content: %q", content)
+	}
+}
+
+func TestPublishNotes(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	for name, content := range map[string]string{
+		"docs/intro": "# Intro\n\nSee [[docs/setup|setup]] and [[private]].",
+		"docs/setup": "# Setup",
+		"private":    "# Private",
+	} {
+		w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: name, Content: content}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+		}
+	}
+
+	w := serve(PublishNotes(cfg), "alice", jsonReq(t, publishReq{Folders: []string{"docs"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to publish notes: %d %s", w.Code, w.Body)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ServePublished(cfg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w = get("/public/alice/docs/intro.html")
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to get published note: %d", w.Code)
+	}
+
+	page := w.Body.String()
+	if !strings.Contains(page, `<a href="setup.html" rel="nofollow">setup</a>`) || strings.Contains(page, "private.html") {
+		t.Errorf("unexpected links in published note:\n%s", page)
+	}
+
+	if w = get("/public/alice/private.html"); w.Code != http.StatusNotFound {
+		t.Errorf("expected unpublished note to be missing, got %d", w.Code)
+	}
+
+	if w = get("/public/alice/docs/"); w.Code != http.StatusNotFound {
+		t.Errorf("expected directory listing to be refused, got %d", w.Code)
+	}
+
+	// Published notes are regenerated when they change
+	w = serve(UpdateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "docs/setup", Content: "# Setup\n\nBack to [[docs/intro]]."}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to update note: %d %s", w.Code, w.Body)
+	}
+
+	publish.Flush()
+	if page = get("/public/alice/docs/setup.html").Body.String(); !strings.Contains(page, "Back to") {
+		t.Errorf("expected published note to be regenerated:\n%s", page)
+	}
+
+	// A note published by name stays published when renamed
+	w = serve(PublishNotes(cfg), "alice", jsonReq(t, publishReq{Notes: []string{"private"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to publish note: %d %s", w.Code, w.Body)
+	}
+
+	w = serve(RenameNote(cfg), "alice", jsonReq(t, noteRenameReq{NoteName: "private", NewName: "public"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to rename note: %d %s", w.Code, w.Body)
+	}
+
+	paths, err := db.GetPublishedPaths("alice")
+	if err != nil || !slices.Contains(paths, "public.md") || slices.Contains(paths, "private.md") {
+		t.Errorf("expected published path to follow the rename, got %v: %v", paths, err)
+	}
+
+	publish.Flush()
+	if w = get("/public/alice/public.html"); w.Code != http.StatusOK {
+		t.Errorf("expected renamed note to be published, got %d", w.Code)
+	}
+
+	w = serve(UnpublishNotes(cfg), "alice", jsonReq(t, publishReq{Notes: []string{"public"}, Folders: []string{"docs"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to unpublish notes: %d %s", w.Code, w.Body)
+	}
+
+	if w = get("/public/alice/"); w.Code != http.StatusNotFound {
+		t.Errorf("expected site to be gone, got %d", w.Code)
+	}
+}
+
+func TestCreateNoteFromTemplate(t *testing.T) {
+	cfg := setup(t, "alice", "bob")
+
+	for _, name := range []string{"templates/meeting", "templates/private"} {
+		w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: name, Content: "# {{title}}\nby {{author}}"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+		}
+	}
+
+	setTemplate := func(name string, shared bool) string {
+		w := serve(SetTemplate(cfg), "alice", jsonReq(t, templateReq{NoteName: name, Shared: shared}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to set template: %d %s", w.Code, w.Body)
+		}
+
+		var resp templateResp
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.TemplateId
+	}
+
+	shared := setTemplate("templates/meeting", true)
+	private := setTemplate("templates/private", false)
+
+	w := serve(CreateNote(cfg), "bob", jsonReq(t, noteCreateReq{NoteName: "standup", TemplateId: shared}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create note from shared template: %d %s", w.Code, w.Body)
+	}
+
+	content, _ := storage.Store.Read("bob", "standup.md")
+	if string(content) != "# standup\nby bob" {
+		t.Errorf("unexpected note content: %q", content)
+	}
+
+	w = serve(CreateNote(cfg), "bob", jsonReq(t, noteCreateReq{NoteName: "other", TemplateId: private}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected other users' private templates to be hidden, got %d", w.Code)
+	}
+
+	w = serve(FetchTemplates(cfg), "bob", jsonReq(t, nil))
+	var templates []utils.NoteTemplate
+	json.NewDecoder(w.Body).Decode(&templates)
+	if len(templates) != 1 || templates[0].Id != shared || templates[0].Owner != "alice" {
+		t.Errorf("unexpected templates: %+v", templates)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	cfg := setup(t, "alice")
+
+	w := serve(CreateNote(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "note", Content: "v1"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create note: %d %s", w.Code, w.Body)
+	}
+
+	w = serve(FetchNoteData(cfg), "alice", jsonReq(t, noteCreateReq{NoteName: "note"}))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an ETag, got %d %q", w.Code, etag)
+	}
+
+	r := jsonReq(t, noteCreateReq{NoteName: "note"})
+	r.Header.Set("If-None-Match", etag)
+	if w = serve(FetchNoteData(cfg), "alice", r); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304 for an unchanged note, got %d", w.Code)
+	}
+
+	update := func(content, ifMatch string) *httptest.ResponseRecorder {
+		r := jsonReq(t, noteCreateReq{NoteName: "note", Content: content})
+		r.Header.Set("If-Match", ifMatch)
+		return serve(UpdateNote(cfg), "alice", r)
+	}
+
+	w = update("v2", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected update at the current revision to succeed, got %d", w.Code)
+	}
+
+	// Someone else's change went in in the meantime
+	if w = update("v2 but stale", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for a stale revision, got %d", w.Code)
+	}
+
+	content, _ := storage.Store.Read("alice", "note.md")
+	if string(content) != "v2" {
+		t.Errorf("stale update shouldn't have been written, got %q", content)
+	}
+
+	// The 412 tells the current revision
+	current := w.Header().Get("ETag")
+
+	// If-Match only takes strong validators
+	if w = update("v3", "W/"+current); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for a weak validator, got %d", w.Code)
+	}
+
+	rename := func(ifMatch string) *httptest.ResponseRecorder {
+		r := jsonReq(t, noteRenameReq{NoteName: "note", NewName: "renamed"})
+		r.Header.Set("If-Match", ifMatch)
+		return serve(RenameNote(cfg), "alice", r)
+	}
+
+	if w = rename(etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 when renaming a stale revision, got %d", w.Code)
+	}
+
+	if w = rename(current); w.Code != http.StatusOK {
+		t.Errorf("expected rename at the current revision to succeed, got %d %s", w.Code, w.Body)
+	}
+}
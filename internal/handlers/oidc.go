@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/oidc"
+	"github.com/musannif-md/musannif/internal/resolver"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// What users logging in through the IdP for the first time are created as,
+// unless their claims map to another role
+const oidcDefaultRole = utils.RoleMember
+
+// Ties a login to the browser that started it
+const oidcLoginCookie = "musannif_oidc_login"
+
+func setOidcLoginCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    value,
+		Path:     "/oidc/",
+		MaxAge:   maxAge,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		// The IdP sends the user back with a top-level navigation
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Sends the user to the identity provider to log in
+func OidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if oidc.Default == nil {
+		http.Error(w, "Single sign-on isn't configured", http.StatusNotFound)
+		return
+	}
+
+	authURL, binding, err := oidc.Default.AuthURL(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to reach the identity provider", http.StatusBadGateway)
+		logger.Log.Error().Err(err).Msg("failed to start oidc login")
+		return
+	}
+
+	setOidcLoginCookie(w, r, binding, int(oidc.LoginTimeout.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Where the identity provider sends the user back to. Creates the user on
+// their first login, then responds like `LoginHandler`.
+func OidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if oidc.Default == nil {
+		http.Error(w, "Single sign-on isn't configured", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+
+	if idpErr := q.Get("error"); idpErr != "" {
+		http.Error(w, "Identity provider refused the login: "+idpErr, http.StatusUnauthorized)
+		return
+	}
+
+	var binding string
+	if c, err := r.Cookie(oidcLoginCookie); err == nil {
+		binding = c.Value
+	}
+
+	// Logins are only ever completed once
+	setOidcLoginCookie(w, r, "", -1)
+
+	identity, err := oidc.Default.Exchange(r.Context(), binding, q.Get("state"), q.Get("code"))
+	if errors.Is(err, oidc.ErrInvalidState) {
+		http.Error(w, "Login expired or was already completed", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in with the identity provider", http.StatusUnauthorized)
+		logger.Log.Error().Err(err).Msg("failed to complete oidc login")
+		return
+	}
+
+	username, role, err := db.ProvisionOidcUser(identity.Issuer, identity.Subject, identity.Username, identity.Role, oidcDefaultRole)
+	if errors.Is(err, db.ErrConflict) {
+		// Linking to an existing local account by name alone would let the IdP
+		// take it over
+		http.Error(w, "Username is taken by another account", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, db.ErrUserDisabled) {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, utils.ErrInvalidUsername) {
+		http.Error(w, "Identity provider's username can't be used: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to provision oidc user")
+		return
+	}
+
+	// The provider stays the source of truth for roles it manages. Changing
+	// one revokes the user's tokens like an admin changing it would.
+	if identity.Role != "" && identity.Role != role {
+		err = db.SetUserRole(username, identity.Role)
+		switch {
+		case errors.Is(err, db.ErrLastAdmin):
+			logger.Log.Warn().Msgf("keeping %s as the last active admin despite their identity provider's role", username)
+		case err != nil:
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to update role of oidc user")
+			return
+		default:
+			role = identity.Role
+			resolver.CloseUserSessions(username)
+		}
+	}
+
+	token, refreshToken, err := startSession(username, role)
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to generate token")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResp{
+		Message:      "Login successful",
+		Role:         role,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
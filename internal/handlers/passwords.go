@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/notify"
+	"github.com/musannif-md/musannif/internal/resolver"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const defaultPasswordResetTTL = time.Hour
+
+type passwordChangeReq struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type passwordResetIssueReq struct {
+	Username string `json:"username"`
+}
+
+type passwordResetIssueResp struct {
+	Message   string `json:"message"`
+	ExpiresAt string `json:"expires_at"` // unix time
+}
+
+type passwordResetReq struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// Sets a new password for the current user, given their old one. Every other
+// login is ended; the caller gets fresh tokens.
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req passwordChangeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if loginThrottled(w, r, username) {
+		return
+	}
+
+	if _, err := db.LoginUser(username, req.OldPassword); err != nil {
+		loginFailed(r, username, "invalid password")
+		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := utils.ValidatePassword(username, req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.SetPassword(username, req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to change password")
+		return
+	}
+
+	err = db.RevokeTokensBefore(username, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to revoke existing tokens", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to revoke tokens after password change")
+		return
+	}
+
+	resolver.CloseUserSessions(username)
+
+	role, err := db.GetUserRole(username)
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to get user role")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	token, refreshToken, err := startSession(username, role)
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to generate token")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResp{
+		Message:      "Password changed",
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// Lets an admin issue a one-time password reset token for a user. The token is
+// handed to the notifier rather than returned, so that only the user sees it.
+func IssuePasswordReset(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		var req passwordResetIssueReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		ttl := cfg.Auth.PasswordResetTTL
+		if ttl <= 0 {
+			ttl = defaultPasswordResetTTL
+		}
+
+		token, expiresAt, err := db.CreatePasswordResetToken(req.Username, username, ttl)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to issue password reset", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to create password reset token")
+			return
+		}
+
+		err = notify.Sender.PasswordReset(req.Username, token, expiresAt)
+		if err != nil {
+			http.Error(w, "Failed to deliver password reset", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to deliver password reset token")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(passwordResetIssueResp{
+			Message:   "Password reset issued",
+			ExpiresAt: strconv.FormatInt(expiresAt.Unix(), 10),
+		})
+	}
+}
+
+// Sets a new password using a reset token, ending every existing login
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if loginThrottled(w, r, "") {
+		return
+	}
+
+	username, err := db.GetPasswordResetUser(req.Token)
+	if errors.Is(err, db.ErrNotFound) {
+		loginFailed(r, "", "invalid reset token")
+		http.Error(w, "Invalid or expired reset token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to look up password reset token")
+		return
+	}
+
+	if err := utils.ValidatePassword(username, req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The token may have been used in the meantime
+	_, err = db.ResetPassword(req.Token, req.NewPassword)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Invalid or expired reset token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to reset password")
+		return
+	}
+
+	err = db.RevokeTokensBefore(username, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to revoke existing tokens", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to revoke tokens after password reset")
+		return
+	}
+
+	resolver.CloseUserSessions(username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResp{Message: "Password reset; log in with the new password"})
+}
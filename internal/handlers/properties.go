@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+func hasPropertyValue(note utils.NoteMetadata, key, value string) bool {
+	switch v := note.Properties[key].(type) {
+	case string:
+		return v == value
+	case []string:
+		return slices.Contains(v, value)
+	default:
+		return false
+	}
+}
+
+func filterNotes(notes []utils.NoteMetadata, properties map[string]string) []utils.NoteMetadata {
+	if len(properties) == 0 {
+		return notes
+	}
+
+	return slices.DeleteFunc(notes, func(note utils.NoteMetadata) bool {
+		for key, value := range properties {
+			if !hasPropertyValue(note, key, value) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Lists sort by their first item
+func sortValue(note utils.NoteMetadata, key string) (string, bool) {
+	switch v := note.Properties[key].(type) {
+	case string:
+		return v, true
+	case []string:
+		if len(v) > 0 {
+			return v[0], true
+		}
+	}
+
+	return "", false
+}
+
+// Numbers compare as numbers, everything else as strings
+func compareValues(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return cmp.Compare(x, y)
+	}
+
+	return cmp.Compare(a, b)
+}
+
+// Sorts by a property's value; notes without it always come last
+func sortNotes(notes []utils.NoteMetadata, key string, descending bool) {
+	slices.SortStableFunc(notes, func(a, b utils.NoteMetadata) int {
+		x, okA := sortValue(a, key)
+		y, okB := sortValue(b, key)
+
+		switch {
+		case !okA && !okB:
+			return 0
+		case !okA:
+			return 1
+		case !okB:
+			return -1
+		}
+
+		if descending {
+			return compareValues(y, x)
+		}
+		return compareValues(x, y)
+	})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/publish"
+)
+
+const publicPath = "/public/"
+
+type publishReq struct {
+	Notes   []string `json:"notes"`
+	Folders []string `json:"folders"` // every note under them, now or later
+}
+
+type publishResp struct {
+	Url   string   `json:"url"`   // of the user's site
+	Paths []string `json:"paths"` // note names, and folders ending with a slash
+}
+
+func decodePublishReq(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var req publishReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	paths := []string{}
+
+	for _, note := range req.Notes {
+		if note == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return nil, false
+		}
+		paths = append(paths, note+".md")
+	}
+
+	for _, folder := range req.Folders {
+		folder = strings.Trim(folder, "/")
+		if folder == "" {
+			http.Error(w, "folder not provided", http.StatusBadRequest)
+			return nil, false
+		}
+		paths = append(paths, folder+"/")
+	}
+
+	if len(paths) == 0 {
+		http.Error(w, "notes or folders not provided", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return paths, true
+}
+
+// Regenerates the user's site and reports what's published on it
+func respondPublished(w http.ResponseWriter, username string) {
+	err := publish.Rebuild(username)
+	if err != nil {
+		http.Error(w, "failed to generate site", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to generate site")
+		return
+	}
+
+	paths, err := db.GetPublishedPaths(username)
+	if err != nil {
+		http.Error(w, "failed to get published paths", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get published paths")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(publishResp{
+		Url:   publicPath + publish.SitePath(username),
+		Paths: paths,
+	})
+}
+
+func PublishNotes(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paths, ok := decodePublishReq(w, r)
+		if !ok {
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+
+		err := db.AddPublishedPaths(username, paths)
+		if err != nil {
+			http.Error(w, "failed to publish notes", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to publish notes")
+			return
+		}
+
+		respondPublished(w, username)
+	}
+}
+
+func UnpublishNotes(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paths, ok := decodePublishReq(w, r)
+		if !ok {
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+
+		err := db.RemovePublishedPaths(username, paths)
+		if err != nil {
+			http.Error(w, "failed to unpublish notes", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to unpublish notes")
+			return
+		}
+
+		respondPublished(w, username)
+	}
+}
+
+func FetchPublished(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		paths, err := db.GetPublishedPaths(username)
+		if err != nil {
+			http.Error(w, "failed to get published paths", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get published paths")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(publishResp{
+			Url:   publicPath + publish.SitePath(username),
+			Paths: paths,
+		})
+	}
+}
+
+// Serves published sites to anyone. Pages can't run scripts, whatever ends up
+// in them.
+func ServePublished(cfg *config.AppConfig) http.Handler {
+	files := http.StripPrefix(publicPath, http.FileServer(publish.FileSystem()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/render"
+	"github.com/musannif-md/musannif/internal/storage"
+)
+
+func RenderNote(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteCreateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		content, err := storage.Store.Read(username, req.NoteName)
+		if errors.Is(err, storage.ErrNotExist) {
+			http.Error(w, "note not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to read note")
+			return
+		}
+
+		// Renders are as fresh as the content they're rendered from
+		etag := noteETag(content)
+		w.Header().Set("ETag", etag)
+
+		if etagListed(r.Header.Get("If-None-Match"), etag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rendered, err := render.Render(content)
+		if err != nil {
+			http.Error(w, "failed to render note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to render note")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rendered)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const maxTagLength = 64
+
+type noteTagsReq struct {
+	NoteName string   `json:"note_name"`
+	Tags     []string `json:"tags"`
+}
+
+// Tags are case-insensitive and can't contain whitespace or commas
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be between 1 and %d characters long", maxTagLength)
+		}
+
+		if strings.ContainsFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }) {
+			return nil, fmt.Errorf("tag %q can't contain whitespace or commas", tag)
+		}
+
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized, nil
+}
+
+// Keeps notes that have every tag in `all`, at least one in `any` (if given)
+// and none in `exclude`
+func filterNotesByTags(notes []utils.NoteMetadata, all, any, exclude []string) []utils.NoteMetadata {
+	if len(all)+len(any)+len(exclude) == 0 {
+		return notes
+	}
+
+	return slices.DeleteFunc(notes, func(note utils.NoteMetadata) bool {
+		for _, tag := range all {
+			if !slices.Contains(note.Tags, tag) {
+				return true
+			}
+		}
+
+		for _, tag := range exclude {
+			if slices.Contains(note.Tags, tag) {
+				return true
+			}
+		}
+
+		if len(any) == 0 {
+			return false
+		}
+
+		for _, tag := range any {
+			if slices.Contains(note.Tags, tag) {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func decodeNoteTagsReq(w http.ResponseWriter, r *http.Request) (noteTagsReq, bool) {
+	var req noteTagsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+
+	if req.NoteName == "" || len(req.Tags) == 0 {
+		http.Error(w, "note name or tags not provided", http.StatusBadRequest)
+		return req, false
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+
+	req.NoteName += ".md"
+	req.Tags = tags
+
+	return req, true
+}
+
+func AddNoteTags(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeNoteTagsReq(w, r)
+		if !ok {
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+
+		err := db.AddNoteTags(username, req.NoteName, req.Tags)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func RemoveNoteTags(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeNoteTagsReq(w, r)
+		if !ok {
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+
+		err := db.RemoveNoteTags(username, req.NoteName, req.Tags)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func FetchTags(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		tags, err := db.GetUserTags(username)
+		if err != nil {
+			http.Error(w, "failed to get user's tags", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get user's tags")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+type templateReq struct {
+	NoteName string `json:"note_name"`
+	Shared   bool   `json:"shared"` // usable by every user, rather than only its owner
+}
+
+type templateResp struct {
+	TemplateId string `json:"template_id"`
+}
+
+// Instantiates a template for a new note called `notename`. Writes an error
+// response and reports false if that can't be done.
+func instantiateTemplate(w http.ResponseWriter, username, notename, templateId string) (string, bool) {
+	id, err := strconv.ParseInt(templateId, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid template id", http.StatusBadRequest)
+		return "", false
+	}
+
+	templateName, owner, err := db.GetTemplate(id, username)
+	if handleLookupErr(w, err, "template") {
+		return "", false
+	}
+
+	content, err := storage.Store.Read(owner, templateName)
+	if errors.Is(err, storage.ErrNotExist) {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, "failed to read template", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to read template")
+		return "", false
+	}
+
+	return utils.ExpandTemplate(string(content), utils.TemplateVars{
+		Title:  path.Base(strings.TrimSuffix(notename, ".md")),
+		Author: username,
+		Now:    time.Now(),
+	}), true
+}
+
+// Makes one of the user's notes a template
+func SetTemplate(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req templateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		id, err := db.SetTemplate(username, req.NoteName, req.Shared)
+		if handleLookupErr(w, err, "note") {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(templateResp{TemplateId: strconv.FormatInt(id, 10)})
+	}
+}
+
+func RemoveTemplate(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req templateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		err := db.RemoveTemplate(username, req.NoteName)
+		if handleLookupErr(w, err, "template") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Lists the user's own templates and those shared by others
+func FetchTemplates(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		templates, err := db.GetTemplates(username)
+		if err != nil {
+			http.Error(w, "failed to get templates", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to get templates")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(templates)
+	}
+}
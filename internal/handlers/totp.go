@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const (
+	defaultTotpIssuer = "musannif"
+	recoveryCodeCount = 10
+)
+
+type totpEnrollResp struct {
+	Secret string `json:"secret"` // base32, for entering by hand
+	URI    string `json:"otpauth_uri"`
+}
+
+type totpCodeReq struct {
+	Code string `json:"code"`
+}
+
+type totpConfirmResp struct {
+	RecoveryCodes []string `json:"recovery_codes"` // shown once; each works a single time
+}
+
+type totpLoginReq struct {
+	MfaToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"` // instead of `code`
+}
+
+type totpDisableReq struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type totpResetReq struct {
+	Username string `json:"username"`
+}
+
+// Checks `code` for the user's secret, refusing codes that were already used
+func checkTotp(username, secret, code string) error {
+	step, ok := utils.ValidateTotp(secret, code, time.Now())
+	if !ok {
+		return db.ErrNotFound
+	}
+
+	return db.UseTotpStep(username, step)
+}
+
+// Starts enrolment in two-factor authentication; it only takes effect once a
+// code from the authenticator is confirmed
+func EnrollTotp(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+
+		secret, err := utils.GenerateTotpSecret()
+		if err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to generate totp secret")
+			return
+		}
+
+		err = db.SetPendingTotp(username, secret)
+		if errors.Is(err, db.ErrConflict) {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to start enrolment", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to store totp secret")
+			return
+		}
+
+		issuer := cfg.App.Name
+		if issuer == "" {
+			issuer = defaultTotpIssuer
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(totpEnrollResp{
+			Secret: secret,
+			URI:    utils.TotpURI(issuer, username, secret),
+		})
+	}
+}
+
+// Enables two-factor authentication given a code for the pending secret, and
+// hands out recovery codes
+func ConfirmTotpHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req totpCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secret, enabled, err := db.GetTotp(username)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Enrol in two-factor authentication first", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to confirm enrolment", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get totp secret")
+		return
+	}
+
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	err = checkTotp(username, secret, req.Code)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrTokenReused) {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to confirm enrolment", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to check totp code")
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Failed to confirm enrolment", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to generate recovery codes")
+		return
+	}
+
+	err = db.EnableTotp(username, codes)
+	if err != nil {
+		http.Error(w, "Failed to confirm enrolment", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to enable totp")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totpConfirmResp{RecoveryCodes: codes})
+}
+
+// Completes a login that `LoginHandler` held back for a second factor
+func LoginTotpHandler(w http.ResponseWriter, r *http.Request) {
+	var req totpLoginReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := utils.ValidateMfaToken(req.MfaToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired login", http.StatusUnauthorized)
+		return
+	}
+
+	username := claims.Username
+
+	if loginThrottled(w, r, username) {
+		return
+	}
+
+	secret, enabled, err := db.GetTotp(username)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !enabled) {
+		http.Error(w, "Two-factor authentication isn't enabled", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get totp secret")
+		return
+	}
+
+	switch {
+	case req.Code != "":
+		err = checkTotp(username, secret, req.Code)
+	case req.RecoveryCode != "":
+		err = db.UseRecoveryCode(username, req.RecoveryCode)
+	default:
+		http.Error(w, "Expected a code or a recovery code", http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrTokenReused) {
+		loginFailed(r, username, "invalid second factor")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to check second factor")
+		return
+	}
+
+	loginSucceeded(r, username)
+
+	role, err := db.GetUserRole(username)
+	if errors.Is(err, db.ErrUserDisabled) {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get user role")
+		return
+	}
+
+	token, refreshToken, err := startSession(username, role)
+	if err != nil {
+		logger.Log.Err(err).Msg("Failed to generate token")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResp{
+		Message:      "Login successful",
+		Role:         role,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// Turns off two-factor authentication for the current user, given their
+// password and a current code
+func DisableTotpHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.Context().Value("username").(string)
+
+	var req totpDisableReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if loginThrottled(w, r, username) {
+		return
+	}
+
+	if _, err := db.LoginUser(username, req.Password); err != nil {
+		loginFailed(r, username, "invalid password")
+		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		return
+	}
+
+	secret, enabled, err := db.GetTotp(username)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !enabled) {
+		http.Error(w, "Two-factor authentication isn't enabled", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to get totp secret")
+		return
+	}
+
+	err = checkTotp(username, secret, req.Code)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrTokenReused) {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err == nil {
+		err = db.RemoveTotp(username)
+	}
+	if err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to remove totp")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Lets an admin turn off a user's two-factor authentication, e.g. when they've
+// lost both their authenticator and recovery codes
+func ResetTotpHandler(w http.ResponseWriter, r *http.Request) {
+	var req totpResetReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := db.RemoveTotp(req.Username)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "User doesn't have two-factor authentication", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		logger.Log.Error().Err(err).Msg("failed to remove totp")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
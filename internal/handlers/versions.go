@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/indexer"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+type noteVersionReq struct {
+	NoteName  string `json:"note_name"`
+	VersionId string `json:"version_id"`
+}
+
+type noteVersionDiffReq struct {
+	NoteName    string `json:"note_name"`
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+}
+
+type noteVersionDiffResp struct {
+	Diff string `json:"diff"`
+}
+
+// Writes the appropriate response for errors returned while looking up notes
+// or versions; returns false if there was no error
+func handleLookupErr(w http.ResponseWriter, err error, what string) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, what+" not found", http.StatusNotFound)
+		return true
+	}
+
+	http.Error(w, "failed to get "+what, http.StatusInternalServerError)
+	logger.Log.Error().Err(err).Msgf("failed to get %s", what)
+	return true
+}
+
+func getVersionContent(username, notename, versionId string) ([]byte, error) {
+	id, err := strconv.ParseInt(versionId, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version id %q: %w", versionId, db.ErrNotFound)
+	}
+
+	return db.GetNoteVersionContent(username, notename, id)
+}
+
+func FetchNoteVersions(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteVersionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" {
+			http.Error(w, "note name not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		versions, err := db.GetNoteVersions(username, req.NoteName)
+		if handleLookupErr(w, err, "note versions") {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versions)
+	}
+}
+
+func FetchNoteVersion(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteVersionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" || req.VersionId == "" {
+			http.Error(w, "note name or version id not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		content, err := getVersionContent(username, req.NoteName, req.VersionId)
+		if handleLookupErr(w, err, "note version") {
+			return
+		}
+
+		data := noteContent{
+			Content: string(content),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+func DiffNoteVersions(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteVersionDiffReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" || req.FromVersion == "" || req.ToVersion == "" {
+			http.Error(w, "note name or version ids not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		from, err := getVersionContent(username, req.NoteName, req.FromVersion)
+		if handleLookupErr(w, err, "note version") {
+			return
+		}
+
+		to, err := getVersionContent(username, req.NoteName, req.ToVersion)
+		if handleLookupErr(w, err, "note version") {
+			return
+		}
+
+		diff, err := utils.UnifiedDiff(
+			string(from),
+			string(to),
+			req.NoteName+"@"+req.FromVersion,
+			req.NoteName+"@"+req.ToVersion,
+		)
+		if errors.Is(err, utils.ErrDiffTooLarge) {
+			http.Error(w, "versions are too large or too different to compare", http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to compare versions", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to compare versions")
+			return
+		}
+
+		data := noteVersionDiffResp{
+			Diff: diff,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// Makes an older version the current content. This is recorded as a new version
+// rather than discarding the ones after it.
+func RestoreNoteVersion(cfg *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req noteVersionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.NoteName == "" || req.VersionId == "" {
+			http.Error(w, "note name or version id not provided", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		req.NoteName += ".md"
+
+		content, err := getVersionContent(username, req.NoteName, req.VersionId)
+		if handleLookupErr(w, err, "note version") {
+			return
+		}
+
+		unlock := lockNote(username, req.NoteName)
+		defer unlock()
+
+		if !checkIfMatch(w, r, username, req.NoteName) {
+			return
+		}
+
+		err = storage.Store.Write(username, req.NoteName, content)
+		if err != nil {
+			http.Error(w, "failed to write note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to write note")
+			return
+		}
+
+		_, err = indexer.NoteSaved(username, username, req.NoteName, content)
+		if err != nil {
+			http.Error(w, "failed to process saved note", http.StatusInternalServerError)
+			logger.Log.Error().Err(err).Msg("failed to process saved note")
+			return
+		}
+
+		commitNotes(cfg, username, username, "Restore "+req.NoteName+" to version "+req.VersionId, req.NoteName)
+
+		w.Header().Set("ETag", noteETag(content))
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,41 @@
+package indexer
+
+import (
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/publish"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Brings everything derived from a note's content up to date once it has been
+// persisted, however it got there. Reports whether the content differs from
+// the last saved version.
+func NoteSaved(owner, author, notename string, content []byte) (bool, error) {
+	changed, err := db.RecordNoteVersion(owner, notename, author, content)
+	if err != nil {
+		return false, err
+	}
+
+	// A typo in the front matter shouldn't keep the note from being saved;
+	// it just won't have any properties until it's fixed
+	properties, err := utils.ParseFrontMatter(content)
+	if err != nil {
+		logger.Log.Warn().Err(err).Msgf("ignoring front matter of %s/%s", owner, notename)
+	}
+
+	err = db.SetNoteProperties(owner, notename, properties)
+	if err != nil {
+		return changed, err
+	}
+
+	err = db.SetNoteLinks(owner, notename, utils.ParseLinks(notename, string(content)))
+	if err != nil {
+		return changed, err
+	}
+
+	if changed {
+		publish.NotesChanged(owner, notename)
+	}
+
+	return changed, nil
+}
@@ -2,13 +2,23 @@ package middlewares
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
 	"github.com/musannif-md/musannif/internal/utils"
 )
 
+// Accepts access tokens only; API tokens are turned away
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return ScopedAuthMiddleware("", next)
+}
+
+// Accepts access tokens, as well as API tokens granted `scope`
+func ScopedAuthMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -22,6 +32,29 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if utils.IsApiToken(tokenString) {
+			username, role, scopes, err := db.AuthenticateApiToken(tokenString)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to check token", http.StatusInternalServerError)
+				logger.Log.Error().Err(err).Msg("failed to authenticate api token")
+				return
+			}
+
+			if scope == "" || !slices.Contains(scopes, scope) {
+				http.Error(w, "Token isn't allowed to do that", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "username", username)
+			ctx = context.WithValue(ctx, "role", role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		claims, err := utils.ValidateToken(tokenString)
 		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -29,6 +62,8 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		ctx := context.WithValue(r.Context(), "username", claims.Username)
+		ctx = context.WithValue(ctx, "role", claims.Role)
+		ctx = context.WithValue(ctx, "claims", claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+// Lets requests through only if the user's role grants `perm`. Goes inside
+// `AuthMiddleware`, which puts the role in the context.
+func RequirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value("role").(string)
+
+		if !utils.HasPermission(role, perm) {
+			http.Error(w, "You don't have permission to do that", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
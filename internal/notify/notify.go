@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/logger"
+)
+
+const BackendLog = "log"
+
+// Delivers messages meant for a particular user outside of the app
+type Notifier interface {
+	PasswordReset(username, token string, expiresAt time.Time) error
+}
+
+var Sender Notifier = LogNotifier{}
+
+func Initialize(cfg *config.AppConfig) error {
+	switch cfg.Notify.Backend {
+	case "", BackendLog:
+		Sender = LogNotifier{}
+
+	default:
+		return fmt.Errorf("unknown notify backend %q", cfg.Notify.Backend)
+	}
+
+	return nil
+}
+
+// Writes messages to the info log, for whoever runs the server to pass on
+type LogNotifier struct{}
+
+func (LogNotifier) PasswordReset(username, token string, expiresAt time.Time) error {
+	logger.Log.Info().
+		Str("username", username).
+		Str("token", token).
+		Time("expires_at", expiresAt).
+		Msg("password reset token issued")
+
+	return nil
+}
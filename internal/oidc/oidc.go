@@ -0,0 +1,460 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultUsernameClaim = "preferred_username"
+
+	// How long a user has to get through the IdP's login page
+	LoginTimeout = 10 * time.Minute
+)
+
+var (
+	ErrInvalidState = errors.New("unknown or expired login state")
+
+	defaultScopes = []string{"openid", "profile", "email"}
+)
+
+// Nil unless single sign-on is enabled
+var Default *Provider
+
+func Initialize(cfg *config.AppConfig) error {
+	o := cfg.OIDC
+	if !o.Enabled {
+		Default = nil
+		return nil
+	}
+
+	if o.Issuer == "" || o.ClientID == "" || o.RedirectURL == "" {
+		return fmt.Errorf("oidc requires an issuer, a client id and a redirect url")
+	}
+
+	for value, role := range o.RoleMapping {
+		if !utils.ValidRole(role) {
+			return fmt.Errorf("oidc role mapping for %q names unknown role %q", value, role)
+		}
+	}
+
+	Default = NewProvider(Options{
+		Issuer:        o.Issuer,
+		ClientID:      o.ClientID,
+		ClientSecret:  o.ClientSecret,
+		RedirectURL:   o.RedirectURL,
+		Scopes:        o.Scopes,
+		UsernameClaim: o.UsernameClaim,
+		RoleClaim:     o.RoleClaim,
+		RoleMapping:   o.RoleMapping,
+	})
+
+	return nil
+}
+
+type Options struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // optional, for confidential clients
+	RedirectURL  string
+	Scopes       []string
+
+	UsernameClaim string            // defaults to "preferred_username"
+	RoleClaim     string            // e.g. "groups"; a string or a list of strings
+	RoleMapping   map[string]string // role claim value -> role
+	DefaultRole   string            // when no role claim value is mapped; defaults to member
+}
+
+// Who the IdP says logged in
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Role     string // empty unless a role claim is configured
+}
+
+type discoveryDoc struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// An OpenID Connect identity provider, logged into with the authorization
+// code flow and PKCE
+type Provider struct {
+	opts   Options
+	client *http.Client
+
+	// Signs login states, and derives their nonces and PKCE verifiers, so
+	// that nothing has to be remembered between a login and its callback
+	secret []byte
+
+	mu        sync.Mutex
+	discovery *discoveryDoc
+	keys      map[string]*rsa.PublicKey // by kid
+}
+
+func NewProvider(opts Options) *Provider {
+	opts.Issuer = strings.TrimSuffix(opts.Issuer, "/")
+
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = defaultScopes
+	}
+
+	if opts.UsernameClaim == "" {
+		opts.UsernameClaim = defaultUsernameClaim
+	}
+
+	if opts.DefaultRole == "" {
+		opts.DefaultRole = utils.RoleMember
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return &Provider{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		secret: secret,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Fetches the provider's metadata the first time it's needed
+func (p *Provider) discover(ctx context.Context) (*discoveryDoc, error) {
+	p.mu.Lock()
+	doc := p.discovery
+	p.mu.Unlock()
+
+	if doc != nil {
+		return doc, nil
+	}
+
+	doc = &discoveryDoc{}
+
+	err := p.getJSON(ctx, p.opts.Issuer+"/.well-known/openid-configuration", doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch provider metadata: %w", err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != p.opts.Issuer {
+		return nil, fmt.Errorf("provider metadata is for issuer %q", doc.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = doc
+	p.mu.Unlock()
+
+	return doc, nil
+}
+
+func (p *Provider) mac(parts ...string) string {
+	h := hmac.New(sha256.New, p.secret)
+	h.Write([]byte(strings.Join(parts, ".")))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Returns where to send the user to log in, along with a binding that has to
+// be kept in their browser (as a cookie) and handed to `Exchange`, so that
+// only the browser that started a login can complete it
+func (p *Provider) AuthURL(ctx context.Context) (string, string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate state: %w", err)
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(LoginTimeout).Unix(), 10)
+	binding := state + "." + expiresAt + "." + p.mac("binding", state, expiresAt)
+
+	challenge := sha256.Sum256([]byte(p.mac("verifier", state)))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.opts.ClientID)
+	q.Set("redirect_uri", p.opts.RedirectURL)
+	q.Set("scope", strings.Join(p.opts.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", p.mac("nonce", state))
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return doc.AuthorizationEndpoint + sep + q.Encode(), binding, nil
+}
+
+// Reports whether `binding` came from `AuthURL` for `state`, and hasn't
+// expired yet
+func (p *Provider) checkBinding(binding, state string) bool {
+	parts := strings.Split(binding, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(p.mac("binding", parts[0], parts[1]))) {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	return err == nil && time.Now().Unix() <= expiresAt
+}
+
+// Trades the code the IdP redirected back with for the identity of whoever
+// logged in. `binding` is what `AuthURL` returned along with the login's URL;
+// codes work once, as the IdP only redeems them once.
+func (p *Provider) Exchange(ctx context.Context, binding, state, code string) (*Identity, error) {
+	if !p.checkBinding(binding, state) {
+		return nil, ErrInvalidState
+	}
+
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.opts.RedirectURL)
+	form.Set("client_id", p.opts.ClientID)
+	form.Set("code_verifier", p.mac("verifier", state))
+	if p.opts.ClientSecret != "" {
+		form.Set("client_secret", p.opts.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err = json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (%s): %w", resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("provider refused code: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	claims, err := p.verifyIDToken(ctx, doc, tokens.IDToken, p.mac("nonce", state))
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	return p.identity(doc, claims)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, doc *discoveryDoc, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(
+		raw,
+		claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.key(ctx, doc, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.opts.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// Looks up a signing key, refetching the key set once for kids it doesn't
+// know yet, since providers rotate keys
+func (p *Provider) key(ctx context.Context, doc *discoveryDoc, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err := p.getJSON(ctx, doc.JwksURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (p *Provider) identity(doc *discoveryDoc, claims jwt.MapClaims) (*Identity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	username, _ := claims[p.opts.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("id token has no %q claim", p.opts.UsernameClaim)
+	}
+
+	id := &Identity{
+		Issuer:   doc.Issuer,
+		Subject:  subject,
+		Username: username,
+	}
+
+	if p.opts.RoleClaim == "" {
+		return id, nil
+	}
+
+	var values []string
+
+	switch v := claims[p.opts.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	// Viper lowercases map keys when reading config
+	for _, v := range values {
+		if role, ok := p.opts.RoleMapping[strings.ToLower(v)]; ok {
+			id.Role = role
+			break
+		}
+	}
+
+	// Someone taken out of a mapped group loses the role that came with it
+	if id.Role == "" {
+		id.Role = p.opts.DefaultRole
+	}
+
+	return id, nil
+}
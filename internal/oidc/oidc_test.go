@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A bare-bones identity provider. Authorization requests are "approved" by
+// calling `authorize` with the URL the user would've been sent to.
+type mockIdp struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	mu    sync.Mutex
+	codes map[string]url.Values // code -> authorization request
+}
+
+func newMockIdp(t *testing.T) *mockIdp {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdp{key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDoc{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JwksURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *mockIdp) authorize(t *testing.T, authURL string) (state, code string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("expected a PKCE challenge, got %q", authURL)
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	code = rand.Text()
+	idp.codes[code] = q
+	return q.Get("state"), code
+}
+
+func (idp *mockIdp) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	idp.mu.Lock()
+	authReq, ok := idp.codes[r.Form.Get("code")]
+	delete(idp.codes, r.Form.Get("code"))
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+
+	if !ok ||
+		r.Form.Get("client_id") != authReq.Get("client_id") ||
+		r.Form.Get("redirect_uri") != authReq.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != authReq.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   authReq.Get("client_id"),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": authReq.Get("nonce"),
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func TestLogin(t *testing.T) {
+	idp := newMockIdp(t)
+	idp.claims = jwt.MapClaims{
+		"sub":                "248289761001",
+		"preferred_username": "jane",
+		"groups":             []string{"eng", "Musannif-Admins"},
+	}
+
+	p := NewProvider(Options{
+		Issuer:      idp.server.URL,
+		ClientID:    "musannif",
+		RedirectURL: "http://localhost:8242/oidc/callback",
+		RoleClaim:   "groups",
+		RoleMapping: map[string]string{"musannif-admins": "admin"},
+	})
+
+	ctx := context.Background()
+
+	authURL, binding, err := p.AuthURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, code := idp.authorize(t, authURL)
+
+	if _, err = p.Exchange(ctx, binding, "forged", code); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected unknown state to be rejected, got %v", err)
+	}
+
+	// Logins can't be completed in a browser other than the one they were
+	// started in
+	_, otherBinding, _ := p.AuthURL(ctx)
+	for _, b := range []string{"", otherBinding, binding + "x"} {
+		if _, err = p.Exchange(ctx, b, state, code); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("expected binding %q to be rejected, got %v", b, err)
+		}
+	}
+
+	id, err := p.Exchange(ctx, binding, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Identity{Issuer: idp.server.URL, Subject: "248289761001", Username: "jane", Role: "admin"}
+	if *id != expected {
+		t.Errorf("expected %+v, got %+v", expected, *id)
+	}
+
+	if _, err = p.Exchange(ctx, binding, state, code); err == nil {
+		t.Error("expected code to work only once")
+	}
+
+	// Leaving the admin group takes the role away
+	idp.claims["groups"] = []string{"eng"}
+
+	authURL, binding, _ = p.AuthURL(ctx)
+	state, code = idp.authorize(t, authURL)
+
+	if id, err = p.Exchange(ctx, binding, state, code); err != nil || id.Role != "member" {
+		t.Errorf("expected unmapped groups to fall back to member, got %+v %v", id, err)
+	}
+
+	// Tokens meant for another client are turned down
+	other := NewProvider(Options{Issuer: idp.server.URL, ClientID: "other", RedirectURL: "http://localhost/cb"})
+
+	authURL, binding, _ = p.AuthURL(ctx)
+	state, code = idp.authorize(t, authURL)
+
+	// Share the login state, keeping the PKCE verifier intact
+	other.secret = p.secret
+	if _, err = other.Exchange(ctx, binding, state, code); err == nil {
+		t.Error("expected code issued to another client to be rejected")
+	}
+}
@@ -0,0 +1,439 @@
+package publish
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/logger"
+	"github.com/musannif-md/musannif/internal/render"
+	"github.com/musannif-md/musannif/internal/storage"
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const (
+	defaultDirectory = "public"
+	indexPage        = "index.html"
+	pageExt          = ".html"
+	attachmentsDir   = "attachments"
+
+	// Saves come in bursts while someone's typing; a site is rebuilt once
+	// they've settled
+	rebuildDelay = time.Second
+)
+
+var (
+	root = defaultDirectory
+
+	// Serializes rebuilds of each user's site
+	siteLocks sync.Map
+
+	// Rebuilds waiting for changes to settle, by owner
+	pendingMu sync.Mutex
+	pending   = map[string]*time.Timer{}
+	running   sync.WaitGroup
+)
+
+func Initialize(cfg *config.AppConfig) {
+	if cfg.App.PublicDirectory != "" {
+		root = cfg.App.PublicDirectory
+	}
+}
+
+// Where a user's site is served from, relative to the public path
+func SitePath(owner string) string {
+	return url.PathEscape(owner) + "/"
+}
+
+// Reports whether `notename` is one of `paths`, or under one of the folders
+// (ending with a slash) among them
+func IsPublished(paths []string, notename string) bool {
+	for _, p := range paths {
+		if p == notename || (strings.HasSuffix(p, "/") && strings.HasPrefix(notename, p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pageName(notename string) string {
+	return strings.TrimSuffix(notename, ".md") + pageExt
+}
+
+// Links from the page at `from` to `to`, both relative to the site's root
+func relLink(from, to string) string {
+	dir := path.Dir(from)
+	up := ""
+
+	for dir != "." && !strings.HasPrefix(to, dir+"/") {
+		dir = path.Dir(dir)
+		up += "../"
+	}
+
+	if dir != "." {
+		to = strings.TrimPrefix(to, dir+"/")
+	}
+
+	parts := strings.Split(to, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+
+	return up + strings.Join(parts, "/")
+}
+
+// Regenerates a user's site from scratch out of the notes they publish, or
+// removes it if they don't publish any. The new site replaces the old one
+// only once it's complete.
+func Rebuild(owner string) error {
+	mu, _ := siteLocks.LoadOrStore(owner, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	if owner == "" || strings.ContainsAny(owner, `/\`) || strings.HasPrefix(owner, ".") {
+		return fmt.Errorf("can't publish a site for user %q", owner)
+	}
+
+	siteDir := filepath.Join(root, owner)
+
+	paths, err := db.GetPublishedPaths(owner)
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		if err = os.RemoveAll(siteDir); err != nil {
+			return fmt.Errorf("failed to remove site: %w", err)
+		}
+		return nil
+	}
+
+	err = os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create public directory: %w", err)
+	}
+
+	// Hidden, so it's never served while it's being built
+	tmp, err := os.MkdirTemp(root, "."+owner+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create site directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = build(owner, paths, tmp)
+	if err != nil {
+		return err
+	}
+
+	old := tmp + ".old"
+	if err = os.Rename(siteDir, old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace site: %w", err)
+	}
+	defer os.RemoveAll(old)
+
+	if err = os.Rename(tmp, siteDir); err != nil {
+		return fmt.Errorf("failed to replace site: %w", err)
+	}
+
+	return nil
+}
+
+func writeFile(dir, name string, content []byte) error {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+
+	err := os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+
+	if err = os.WriteFile(p, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func build(owner string, paths []string, dir string) error {
+	notes, err := db.GetUserNoteMetadata(owner)
+	if err != nil {
+		return err
+	}
+
+	contents := map[string][]byte{}
+	names := []string{}
+
+	for _, note := range notes {
+		if !IsPublished(paths, note.Name) {
+			continue
+		}
+
+		content, err := storage.Store.Read(owner, note.Name)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		contents[note.Name] = content
+		names = append(names, note.Name)
+	}
+
+	slices.Sort(names)
+
+	sidebar := func(from, current string) []pageLink {
+		links := make([]pageLink, 0, len(names))
+		for _, name := range names {
+			links = append(links, pageLink{
+				Name:    strings.TrimSuffix(name, ".md"),
+				Href:    relLink(from, pageName(name)),
+				Current: name == current,
+			})
+		}
+		return links
+	}
+
+	for _, name := range names {
+		p, err := buildPage(owner, name, contents[name], dir, func(target string) bool {
+			_, ok := contents[target]
+			return ok
+		})
+		if err != nil {
+			return err
+		}
+
+		p.Sidebar = sidebar(pageName(name), name)
+
+		err = writePage(dir, pageName(name), p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePage(dir, indexPage, page{
+		Owner:   owner,
+		Home:    indexPage,
+		Title:   owner,
+		Sidebar: sidebar(indexPage, ""),
+		IsIndex: true,
+	})
+}
+
+// Renders one published note, copying its attachments into the site. Links
+// to other published notes lead to their pages; links to anything else are
+// reduced to their text.
+func buildPage(owner, name string, content []byte, dir string, published func(string) bool) (page, error) {
+	from := pageName(name)
+
+	md := utils.MapNoteLinks(name, string(content), func(target, anchor, text string) string {
+		if !published(target) {
+			return text
+		}
+
+		if anchor != "" {
+			anchor = "#" + anchor
+		}
+
+		return "[" + text + "](" + relLink(from, pageName(target)) + anchor + ")"
+	})
+
+	attachments, err := db.GetNoteAttachments(owner, name)
+	if err != nil {
+		return page{}, err
+	}
+
+	files := map[string]string{} // by attachment id
+	for _, a := range attachments {
+		content, err := storage.Store.Read(owner, storage.AttachmentName(a.Id))
+		if err != nil {
+			return page{}, fmt.Errorf("failed to read attachment %s: %w", a.Id, err)
+		}
+
+		files[a.Id] = path.Join(attachmentsDir, a.Id, a.Filename)
+		if err = writeFile(dir, files[a.Id], content); err != nil {
+			return page{}, err
+		}
+	}
+
+	md = utils.RewriteEmbeds(md, func(target string) (string, bool) {
+		id, ok := strings.CutPrefix(target, "/attachment/")
+		if !ok || files[id] == "" {
+			return "", false
+		}
+
+		return relLink(from, files[id]), true
+	})
+
+	rendered, err := render.Render([]byte(md))
+	if err != nil {
+		return page{}, err
+	}
+
+	p := page{
+		Owner:   owner,
+		Home:    relLink(from, indexPage),
+		Title:   path.Base(strings.TrimSuffix(name, ".md")),
+		Content: template.HTML(rendered.Html),
+	}
+
+	for _, entry := range rendered.Toc {
+		p.Toc = append(p.Toc, pageLink{Name: entry.Text, Href: "#" + entry.Anchor})
+	}
+
+	backlinks, err := db.GetBacklinks(owner, name)
+	if err != nil {
+		return page{}, err
+	}
+
+	for _, source := range backlinks {
+		if source != name && published(source) {
+			p.Backlinks = append(p.Backlinks, pageLink{
+				Name: strings.TrimSuffix(source, ".md"),
+				Href: relLink(from, pageName(source)),
+			})
+		}
+	}
+
+	return p, nil
+}
+
+func writePage(dir, name string, p page) error {
+	var buf bytes.Buffer
+
+	err := pageTemplate.Execute(&buf, p)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s: %w", name, err)
+	}
+
+	return writeFile(dir, name, buf.Bytes())
+}
+
+// Rebuilds the owner's site in the background if any of the named notes is
+// published there, once no more changes have come in for a while
+func NotesChanged(owner string, notenames ...string) {
+	paths, err := db.GetPublishedPaths(owner)
+	if err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to get published paths of %s", owner)
+		return
+	}
+
+	if !slices.ContainsFunc(notenames, func(name string) bool { return IsPublished(paths, name) }) {
+		return
+	}
+
+	scheduleRebuild(owner)
+}
+
+func scheduleRebuild(owner string) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	// A timer that already fired may be rebuilding from before this change
+	if t, ok := pending[owner]; ok && t.Stop() {
+		t.Reset(rebuildDelay)
+		return
+	}
+
+	var t *time.Timer
+	running.Add(1)
+
+	t = time.AfterFunc(rebuildDelay, func() {
+		defer running.Done()
+
+		pendingMu.Lock()
+		current := pending[owner] == t
+		if current {
+			delete(pending, owner)
+		}
+		pendingMu.Unlock()
+
+		// Otherwise, Flush or a newer timer took over
+		if current {
+			rebuildLogged(owner)
+		}
+	})
+
+	pending[owner] = t
+}
+
+func rebuildLogged(owner string) {
+	if err := Rebuild(owner); err != nil {
+		logger.Log.Error().Err(err).Msgf("failed to rebuild site of %s", owner)
+	}
+}
+
+// Runs pending rebuilds right away and waits for them, and for those already
+// running, to be done. For shutting down, and for tests.
+func Flush() {
+	pendingMu.Lock()
+	owners := make([]string, 0, len(pending))
+	stopped := 0
+	for owner, t := range pending {
+		owners = append(owners, owner)
+		if t.Stop() {
+			stopped++
+		}
+		delete(pending, owner)
+	}
+	pendingMu.Unlock()
+
+	for _, owner := range owners {
+		rebuildLogged(owner)
+	}
+
+	running.Add(-stopped)
+	running.Wait()
+}
+
+// Serves generated sites, without listing directories or exposing sites that
+// are still being built
+type siteFS struct {
+	fs http.FileSystem
+}
+
+func (s siteFS) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return nil, os.ErrNotExist
+		}
+	}
+
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.IsDir() {
+		index, err := s.fs.Open(strings.TrimSuffix(name, "/") + "/" + indexPage)
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		index.Close()
+	}
+
+	return f, nil
+}
+
+func FileSystem() http.FileSystem {
+	return siteFS{fs: http.Dir(root)}
+}
@@ -0,0 +1,86 @@
+package publish
+
+import "html/template"
+
+type pageLink struct {
+	Name    string
+	Href    string
+	Current bool
+}
+
+type page struct {
+	Owner     string
+	Home      string // link to the index
+	Title     string
+	Sidebar   []pageLink
+	Toc       []pageLink
+	Content   template.HTML // already sanitized
+	Backlinks []pageLink
+	IsIndex   bool
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · {{.Owner}}</title>
+<style>
+body { margin: 0; display: flex; font-family: system-ui, sans-serif; line-height: 1.5; color: #222; }
+nav { width: 16rem; flex-shrink: 0; padding: 1rem; border-right: 1px solid #ddd; min-height: 100vh; box-sizing: border-box; }
+nav ul { list-style: none; padding: 0; }
+nav a.current { font-weight: bold; }
+main { flex: 1; max-width: 48rem; padding: 1rem 2rem; }
+aside { border-top: 1px solid #ddd; margin-top: 2rem; }
+img { max-width: 100%; }
+pre { overflow-x: auto; background: #f6f6f6; padding: 0.5rem; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ddd; padding: 0.25rem 0.5rem; }
+</style>
+</head>
+<body>
+<nav>
+<a href="{{.Home}}">{{.Owner}}</a>
+<ul>
+{{- range .Sidebar}}
+<li><a href="{{.Href}}"{{if .Current}} class="current"{{end}}>{{.Name}}</a></li>
+{{- end}}
+</ul>
+</nav>
+<main>
+{{- if .IsIndex}}
+<h1>{{.Owner}}</h1>
+<ul>
+{{- range .Sidebar}}
+<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+{{- else}}
+{{- if .Toc}}
+<details>
+<summary>Contents</summary>
+<ul>
+{{- range .Toc}}
+<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+</details>
+{{- end}}
+<article>
+{{.Content}}
+</article>
+{{- if .Backlinks}}
+<aside>
+<h2>Linked from</h2>
+<ul>
+{{- range .Backlinks}}
+<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+</aside>
+{{- end}}
+{{- end}}
+</main>
+</body>
+</html>
+`))
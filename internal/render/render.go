@@ -0,0 +1,162 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/musannif-md/musannif/internal/db"
+	"github.com/musannif-md/musannif/internal/utils"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// Rendered notes kept around; notes are rendered again once evicted
+const cacheSize = 256
+
+var (
+	md = goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM, // tables, task lists, strikethrough, autolinks
+			extension.Footnote,
+		),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		// Raw HTML is let through here and dealt with by the sanitizer
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+
+	policy = newPolicy()
+
+	cache = renderCache{entries: map[string]utils.RenderedNote{}}
+)
+
+// What users may write in notes is treated like any other user-generated
+// content; on top of that, keep what the markdown extensions produce
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\w:-]+$`)).Globally()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(footnote-ref|footnote-backref|footnotes)$`)).OnElements("a", "div")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|backlink|endnotes)$`)).OnElements("a", "div")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+
+	return p
+}
+
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[string]utils.RenderedNote
+	order   []string // oldest first
+}
+
+func (c *renderCache) get(revision string) (utils.RenderedNote, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.entries[revision]
+	return r, ok
+}
+
+func (c *renderCache) put(r utils.RenderedNote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[r.Revision]; ok {
+		return
+	}
+
+	if len(c.order) >= cacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	c.entries[r.Revision] = r
+	c.order = append(c.order, r.Revision)
+}
+
+// Concatenates the text inside a node, skipping any markup
+func nodeText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Text:
+			sb.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(n.Value)
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	return sb.String()
+}
+
+func tableOfContents(doc ast.Node, source []byte) []utils.TocEntry {
+	toc := []utils.TocEntry{}
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		id, _ := heading.AttributeString("id")
+		anchor, _ := id.([]byte)
+
+		toc = append(toc, utils.TocEntry{
+			Level:  fmt.Sprint(heading.Level),
+			Text:   nodeText(heading, source),
+			Anchor: string(anchor),
+		})
+
+		return ast.WalkSkipChildren, nil
+	})
+
+	return toc
+}
+
+// Renders a note's markdown to sanitized HTML, leaving its front matter out.
+// Results are cached by the note's content hash.
+func Render(content []byte) (utils.RenderedNote, error) {
+	revision := db.HashContent(content)
+
+	if r, ok := cache.get(revision); ok {
+		return r, nil
+	}
+
+	source := utils.StripFrontMatter(content)
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	var buf bytes.Buffer
+	err := md.Renderer().Render(&buf, source, doc)
+	if err != nil {
+		return utils.RenderedNote{}, fmt.Errorf("failed to render note: %w", err)
+	}
+
+	r := utils.RenderedNote{
+		Revision: revision,
+		Html:     policy.Sanitize(buf.String()),
+		Toc:      tableOfContents(doc, source),
+	}
+
+	cache.put(r)
+
+	return r, nil
+}
@@ -0,0 +1,52 @@
+package render
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/musannif-md/musannif/internal/utils"
+)
+
+const note = "---\ntitle: Front matter\n---\n" +
+	"# Hello *World*\n\n## Tasks\n\n- [x] done\n- [ ] todo\n\n" +
+	"| a | b |\n|---|---|\n| 1 | 2 |\n\n" +
+	"Text[^1] <script>alert(1)</script> <a href=\"/x\" onclick=\"steal()\">link</a> [js](javascript:alert(1))\n\n" +
+	"[^1]: A footnote.\n"
+
+func TestRender(t *testing.T) {
+	r, err := Render([]byte(note))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<h1 id="hello-world">Hello <em>World</em></h1>`,
+		`<input checked="" disabled="" type="checkbox">`,
+		`<td>1</td>`,
+		`<a href="#fn:1" class="footnote-ref" role="doc-noteref"`,
+		`<li id="fn:1">`,
+	} {
+		if !strings.Contains(r.Html, expected) {
+			t.Errorf("expected %q in rendered note:\n%s", expected, r.Html)
+		}
+	}
+
+	for _, unexpected := range []string{"title:", "<script", "onclick", "javascript:"} {
+		if strings.Contains(r.Html, unexpected) {
+			t.Errorf("didn't expect %q in rendered note:\n%s", unexpected, r.Html)
+		}
+	}
+
+	expectedToc := []utils.TocEntry{
+		{Level: "1", Text: "Hello World", Anchor: "hello-world"},
+		{Level: "2", Text: "Tasks", Anchor: "tasks"},
+	}
+	if !slices.Equal(r.Toc, expectedToc) {
+		t.Errorf("unexpected table of contents: %+v", r.Toc)
+	}
+
+	if cached, _ := Render([]byte(note)); cached.Revision != r.Revision || cached.Html != r.Html {
+		t.Error("expected the same revision to render the same")
+	}
+}
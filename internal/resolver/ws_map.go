@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/musannif-md/musannif/internal/config"
+	"github.com/musannif-md/musannif/internal/logger"
 	"github.com/musannif-md/musannif/internal/utils"
 
 	"github.com/google/uuid"
@@ -19,9 +20,12 @@ const (
 )
 
 type sessionInfo struct {
+	owner    string
+	noteName string
 	host     *websocket.Conn
 	solver   *DiffSolver
 	sockets  []*websocket.Conn
+	users    []string // who each socket belongs to
 	channels []*chan error
 }
 
@@ -47,10 +51,10 @@ func OnClientConnect(
 	defer m.mu.Unlock()
 
 	si, sessionExists := m.conns[uuid]
+	username := r.Context().Value("username").(string)
 
 	// Session initiator must provide notename via query parameter!
 	if !sessionExists {
-		username := r.Context().Value("username").(string)
 		noteName := r.URL.Query().Get("note_name")
 		if noteName == "" {
 			return fmt.Errorf("expected note name from session initiator `/note_name`")
@@ -61,7 +65,10 @@ func OnClientConnect(
 		path := filepath.Join(cfg.App.NoteDirectory, username, noteName)
 
 		si = sessionInfo{
+			owner:    username,
+			noteName: noteName,
 			sockets:  make([]*websocket.Conn, 0, WS_ARR_START_CAP),
+			users:    make([]string, 0, WS_ARR_START_CAP),
 			channels: make([]*chan error, 0, WS_ARR_START_CAP),
 			solver:   &DiffSolver{fpath: path},
 		}
@@ -75,6 +82,7 @@ func OnClientConnect(
 	}
 
 	si.sockets = append(si.sockets, ws)
+	si.users = append(si.users, username)
 	si.channels = append(si.channels, &readerFinished)
 	m.conns[uuid] = si
 
@@ -121,6 +129,7 @@ func OnClientDisconnect(uuid uuid.UUID, ws *websocket.Conn) error {
 	for i, s := range si.sockets {
 		if s == ws {
 			si.sockets = slices.Delete(si.sockets, i, i+1)
+			si.users = slices.Delete(si.users, i, i+1)
 			si.channels = slices.Delete(si.channels, i, i+1)
 		}
 	}
@@ -148,3 +157,95 @@ func OnClientDisconnect(uuid uuid.UUID, ws *websocket.Conn) error {
 
 	return nil
 }
+
+// Closes every connection belonging to `username`, e.g. once they've logged
+// out of all devices. Returns how many were closed.
+func CloseUserSessions(username string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	closed := 0
+	closeErr := fmt.Errorf("logged out")
+
+	for sid, si := range m.conns {
+		for i, s := range si.sockets {
+			if si.users[i] != username {
+				continue
+			}
+
+			err := utils.WriteCloseMsg(s, websocket.ClosePolicyViolation, closeErr)
+			if err != nil {
+				logger.Log.Err(err).Msgf("%s in session id [%s]", utils.UnableToSendCloseMsg, sid.String())
+			}
+
+			// Fails the connection's pending read, so its handler returns and
+			// removes it from the session
+			s.Close()
+			closed++
+		}
+	}
+
+	return closed
+}
+
+// Closes every connection to a session editing the note, e.g. once it's been
+// deleted. Returns how many were closed.
+func CloseNoteSessions(owner, noteName string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	closed := 0
+	closeErr := fmt.Errorf("note deleted")
+
+	for sid, si := range m.conns {
+		if si.owner != owner || si.noteName != noteName {
+			continue
+		}
+
+		for _, s := range si.sockets {
+			err := utils.WriteCloseMsg(s, websocket.ClosePolicyViolation, closeErr)
+			if err != nil {
+				logger.Log.Err(err).Msgf("%s in session id [%s]", utils.UnableToSendCloseMsg, sid.String())
+			}
+
+			s.Close()
+			closed++
+		}
+	}
+
+	return closed
+}
+
+// Sent to clients when a note's content was replaced outside of their session
+type Op struct {
+	Type   string `json:"type"`   // "replace"
+	Source string `json:"source"` // "external"
+	Text   string `json:"text"`
+}
+
+// Pushes content written to a note from outside the server (e.g. an editor
+// working on the file directly) to every session editing that note
+func OnExternalChange(owner, noteName string, content []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op := Op{
+		Type:   "replace",
+		Source: "external",
+		Text:   string(content),
+	}
+
+	for sid, si := range m.conns {
+		if si.owner != owner || si.noteName != noteName {
+			continue
+		}
+
+		for _, c := range si.sockets {
+			err := c.WriteJSON(op)
+			if err != nil {
+				// The connection's reader notices and cleans up after itself
+				logger.Log.Err(err).Msgf("failed to push external change to session id [%s]", sid.String())
+			}
+		}
+	}
+}
@@ -6,26 +6,112 @@ import (
 	"github.com/musannif-md/musannif/internal/config"
 	"github.com/musannif-md/musannif/internal/handlers"
 	"github.com/musannif-md/musannif/internal/middlewares"
+	"github.com/musannif-md/musannif/internal/utils"
 )
 
 func AddRoutes(mux *http.ServeMux, cfg *config.AppConfig) {
-	// JWT protection
+	// JWT protection; API tokens aren't accepted
 	auth := func(handler http.HandlerFunc) http.HandlerFunc {
 		return middlewares.AuthMiddleware(handler)
 	}
 
+	// JWT protection or an API token with the given scope, for users whose role
+	// grants the given permission
+	read := func(handler http.HandlerFunc) http.HandlerFunc {
+		return middlewares.ScopedAuthMiddleware(utils.ScopeNotesRead, middlewares.RequirePermission(utils.PermNotesRead, handler))
+	}
+	write := func(handler http.HandlerFunc) http.HandlerFunc {
+		return middlewares.ScopedAuthMiddleware(utils.ScopeNotesWrite, middlewares.RequirePermission(utils.PermNotesWrite, handler))
+	}
+	publish := func(handler http.HandlerFunc) http.HandlerFunc {
+		return middlewares.ScopedAuthMiddleware(utils.ScopePublish, middlewares.RequirePermission(utils.PermPublish, handler))
+	}
+
+	// JWT protection, for users whose role grants the given permission
+	allowed := func(perm string, handler http.HandlerFunc) http.HandlerFunc {
+		return middlewares.AuthMiddleware(middlewares.RequirePermission(perm, handler))
+	}
+
 	// Auth
 	mux.HandleFunc("POST /login", handlers.LoginHandler)
-	mux.HandleFunc("POST /signup", handlers.SignupHandler)
+	mux.HandleFunc("POST /signup", handlers.SignupHandler(cfg))
+	mux.HandleFunc("POST /token/refresh", handlers.RefreshTokenHandler)                                            // Trade a refresh token for new access and refresh tokens
+	mux.HandleFunc("POST /logout", auth(handlers.LogoutHandler))                                                   // Revoke the current access token (and optionally its refresh token)
+	mux.HandleFunc("POST /logout-all", auth(handlers.LogoutAllHandler))                                            // Revoke every token issued to the user before a given time, closing their websocket sessions
+	mux.HandleFunc("POST /password", auth(handlers.ChangePasswordHandler))                                         // Change the user's password, given the old one
+	mux.HandleFunc("POST /password-reset", handlers.ResetPasswordHandler)                                          // Set a new password with a one-time reset token
+	mux.HandleFunc("POST /issue-password-reset", allowed(utils.PermUsersManage, handlers.IssuePasswordReset(cfg))) // Admins: send a user a password reset token
+	mux.HandleFunc("POST /login-attempts", allowed(utils.PermUsersManage, handlers.FetchLoginAttemptsHandler))     // Admins: list failed logins, newest first
+	mux.HandleFunc("GET /oidc/login", handlers.OidcLoginHandler)                                                   // Redirect to the identity provider to log in (single sign-on)
+	mux.HandleFunc("GET /oidc/callback", handlers.OidcCallbackHandler)                                             // Where the identity provider redirects back to; responds like `/login`
+	mux.HandleFunc("POST /login/2fa", handlers.LoginTotpHandler)                                                   // Finish a login with a TOTP or recovery code
+	mux.HandleFunc("POST /2fa/enroll", auth(handlers.EnrollTotp(cfg)))                                             // Start enrolling in two-factor authentication
+	mux.HandleFunc("POST /2fa/confirm", auth(handlers.ConfirmTotpHandler))                                         // Enable two-factor authentication with a first code, getting recovery codes
+	mux.HandleFunc("POST /2fa/disable", auth(handlers.DisableTotpHandler))                                         // Turn two-factor authentication off, given the password and a code
+	mux.HandleFunc("POST /2fa/reset", allowed(utils.PermUsersManage, handlers.ResetTotpHandler))                   // Admins: turn a user's two-factor authentication off
+
+	// User management
+	mux.HandleFunc("POST /users", allowed(utils.PermUsersManage, handlers.FetchUsersHandler))             // Admins: list every user with their role and status
+	mux.HandleFunc("POST /user", allowed(utils.PermUsersManage, handlers.CreateUserHandler))              // Admins: create a user with a given role
+	mux.HandleFunc("POST /user-role", allowed(utils.PermUsersManage, handlers.SetUserRoleHandler))        // Admins: change a user's role
+	mux.HandleFunc("POST /disable-user", allowed(utils.PermUsersManage, handlers.SetUserDisabledHandler)) // Admins: disable (or re-enable) a user, ending their sessions
+	mux.HandleFunc("POST /del-user", allowed(utils.PermUsersManage, handlers.DeleteUser(cfg)))            // Admins: delete a user, transferring their notes to another user or deleting them
+
+	// Invitations
+	mux.HandleFunc("POST /invitation", allowed(utils.PermUsersManage, handlers.CreateInvitation(cfg)))       // Admins: create a single-use code for signing up with a given role
+	mux.HandleFunc("POST /invitations", allowed(utils.PermUsersManage, handlers.FetchInvitationsHandler))    // Admins: list outstanding invitations
+	mux.HandleFunc("POST /del-invitation", allowed(utils.PermUsersManage, handlers.DeleteInvitationHandler)) // Admins: withdraw an invitation
+
+	// API tokens
+	mux.HandleFunc("POST /api-token", allowed(utils.PermApiTokens, handlers.CreateApiTokenHandler))     // Create a scoped token for scripts, sent as a bearer token like a JWT
+	mux.HandleFunc("POST /api-tokens", allowed(utils.PermApiTokens, handlers.FetchApiTokensHandler))    // List the user's API tokens
+	mux.HandleFunc("POST /del-api-token", allowed(utils.PermApiTokens, handlers.DeleteApiTokenHandler)) // Revoke an API token
 
 	// Single note
-	mux.HandleFunc("POST /note", auth(handlers.CreateNote(cfg)))        // Upload a note to the user's directory
-	mux.HandleFunc("POST /get-note", auth(handlers.FetchNoteData(cfg))) // Get the contents of one note in the user's directory
-	mux.HandleFunc("POST /del-note", auth(handlers.DeleteNote(cfg)))    // Delete a note from the user's directory
+	mux.HandleFunc("POST /note", write(handlers.CreateNote(cfg)))              // Upload a note to the user's directory
+	mux.HandleFunc("POST /get-note", read(handlers.FetchNoteData(cfg)))        // Get the contents of one note in the user's directory
+	mux.HandleFunc("POST /update-note", write(handlers.UpdateNote(cfg)))       // Overwrite the contents of an existing note
+	mux.HandleFunc("POST /rename-note", write(handlers.RenameNote(cfg)))       // Rename a note in the user's directory
+	mux.HandleFunc("POST /del-note", write(handlers.DeleteNote(cfg)))          // Delete a note from the user's directory
+	mux.HandleFunc("POST /note-history", read(handlers.FetchNoteHistory(cfg))) // List git commits touching a note (git storage only)
+	mux.HandleFunc("POST /render-note", read(handlers.RenderNote(cfg)))        // Render a note to sanitized HTML, along with a table of contents
+
+	// Templates
+	mux.HandleFunc("POST /template", write(handlers.SetTemplate(cfg)))        // Make a note a template (optionally shared with every user), for `/note` to create notes from
+	mux.HandleFunc("POST /del-template", write(handlers.RemoveTemplate(cfg))) // Stop a note from being a template
+	mux.HandleFunc("POST /templates", read(handlers.FetchTemplates(cfg)))     // List the user's templates and those shared by others
+
+	// Note versions
+	mux.HandleFunc("POST /note-versions", read(handlers.FetchNoteVersions(cfg)))          // List a note's versions, newest first
+	mux.HandleFunc("POST /get-note-version", read(handlers.FetchNoteVersion(cfg)))        // Get the contents of one version of a note
+	mux.HandleFunc("POST /diff-note-versions", read(handlers.DiffNoteVersions(cfg)))      // Unified diff between two versions of a note
+	mux.HandleFunc("POST /restore-note-version", write(handlers.RestoreNoteVersion(cfg))) // Make an older version the note's current content
+
+	// Attachments
+	mux.HandleFunc("POST /attachment", write(handlers.UploadAttachment(cfg)))      // Upload a file (multipart: `note_name`, `file`) attached to a note
+	mux.HandleFunc("GET /attachment/{id}", read(handlers.DownloadAttachment(cfg))) // Download an attachment of one of the user's notes
+	mux.HandleFunc("POST /attachments", read(handlers.FetchNoteAttachments(cfg)))  // List a note's attachments
+	mux.HandleFunc("POST /del-attachment", write(handlers.DeleteAttachment(cfg)))  // Delete an attachment
 
 	// User & note metadata
-	mux.HandleFunc("POST /notes", auth(handlers.FetchNoteList(cfg))) // Return a list of notes in user's directory
+	mux.HandleFunc("POST /notes", read(handlers.FetchNoteList(cfg)))           // Return a list of notes in user's directory, optionally filtered by properties/tags
+	mux.HandleFunc("POST /note-tags", write(handlers.AddNoteTags(cfg)))        // Add tags to a note
+	mux.HandleFunc("POST /del-note-tags", write(handlers.RemoveNoteTags(cfg))) // Remove tags from a note
+	mux.HandleFunc("POST /tags", read(handlers.FetchTags(cfg)))                // List the user's tags along with how many notes have each
+	mux.HandleFunc("POST /export", read(handlers.ExportNotes(cfg)))            // Download a zip of the user's notes (or a folder's), attachments and a manifest
+	mux.HandleFunc("POST /import", write(handlers.ImportNotes(cfg)))           // Create notes and attachments from a zip or tarball (multipart: `file`, optional `folder`)
+
+	// Publishing
+	mux.HandleFunc("POST /publish", publish(handlers.PublishNotes(cfg)))     // Add notes or folders to the user's public site
+	mux.HandleFunc("POST /unpublish", publish(handlers.UnpublishNotes(cfg))) // Take notes or folders off the user's public site
+	mux.HandleFunc("POST /published", read(handlers.FetchPublished(cfg)))    // List what the user publishes, and where
+	mux.Handle("GET /public/", handlers.ServePublished(cfg))                 // Published sites, without authentication
+
+	// Links between notes
+	mux.HandleFunc("POST /backlinks", read(handlers.FetchBacklinks(cfg)))      // List the notes linking to a note
+	mux.HandleFunc("POST /broken-links", read(handlers.FetchBrokenLinks(cfg))) // List links to notes that don't exist
+	mux.HandleFunc("POST /link-graph", read(handlers.FetchLinkGraph(cfg)))     // Every note as a node, and an edge for every link between two notes
 
 	// Connection
-	mux.HandleFunc("/connect", auth(handlers.CreateWsConn(cfg))) // Establish connection and start sending/receiving diffs
+	mux.HandleFunc("/connect", write(handlers.CreateWsConn(cfg))) // Establish connection and start sending/receiving diffs
 }
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stores notes as files under <root>/<owner>/<name>
+type FsStore struct {
+	root string
+}
+
+func NewFsStore(root string) *FsStore {
+	return &FsStore{root: root}
+}
+
+func (s *FsStore) path(owner, name string) (string, error) {
+	base := filepath.Join(s.root, owner)
+	path := filepath.Join(base, filepath.FromSlash(name))
+
+	// Don't let names like "../other-user/note.md" escape the owner's directory
+	if !strings.HasPrefix(path, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid note name %q", name)
+	}
+
+	return path, nil
+}
+
+func (s *FsStore) Read(owner, name string) ([]byte, error) {
+	path, err := s.path(owner, name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read note file %s: %w", path, err)
+	}
+
+	return content, nil
+}
+
+func (s *FsStore) Write(owner, name string, content []byte) error {
+	path, err := s.path(owner, name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error initializing note directory: %w", err)
+	}
+
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write note file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (s *FsStore) Delete(owner, name string) error {
+	path, err := s.path(owner, name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete note file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (s *FsStore) Rename(owner, oldName, newName string) error {
+	oldPath, err := s.path(owner, oldName)
+	if err != nil {
+		return err
+	}
+
+	newPath, err := s.path(owner, newName)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(newPath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error initializing note directory: %w", err)
+	}
+
+	err = os.Rename(oldPath, newPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename note file %s: %w", oldPath, err)
+	}
+
+	return nil
+}
+
+// Lists every file in the owner's directory, skipping hidden ones (e.g. `.git`)
+func (s *FsStore) List(owner string) ([]string, error) {
+	base := filepath.Join(s.root, owner)
+	names := []string{}
+
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == base {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+
+		if path != base && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notes of %s: %w", owner, err)
+	}
+
+	return names, nil
+}
+
+func (s *FsStore) ListDir(owner, dir string) ([]string, error) {
+	base := filepath.Join(s.root, owner)
+	names := []string{}
+
+	path, err := s.path(owner, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s of %s: %w", dir, owner, err)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service       = "s3"
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3DefaultRegion = "us-east-1"
+	s3Timeout       = 30 * time.Second
+	amzDateFormat   = "20060102T150405Z"
+)
+
+type S3Options struct {
+	Endpoint  string // e.g. "http://localhost:9000"
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to every object key
+	AccessKey string
+	SecretKey string
+}
+
+// Stores notes as objects under <prefix>/<owner>/<name> in an S3-compatible
+// bucket, addressed path-style (as MinIO and most self-hosted stores expect)
+type S3Store struct {
+	opts   S3Options
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Store(opts S3Options) *S3Store {
+	if opts.Region == "" {
+		opts.Region = s3DefaultRegion
+	}
+
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+
+	return &S3Store{
+		opts:   opts,
+		client: &http.Client{Timeout: s3Timeout},
+		now:    time.Now,
+	}
+}
+
+func (s *S3Store) key(owner, name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid note name %q", name)
+		}
+	}
+
+	return s.ownerPrefix(owner) + name, nil
+}
+
+func (s *S3Store) ownerPrefix(owner string) string {
+	if s.opts.Prefix == "" {
+		return owner + "/"
+	}
+
+	return s.opts.Prefix + "/" + owner + "/"
+}
+
+func (s *S3Store) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(s.opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+
+	u.Path = "/" + s.opts.Bucket + "/" + key
+	u.RawPath = "/" + s.opts.Bucket + "/" + uriEncode(key, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request: %w", err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	signV4(req, body, s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s failed: %w", method, key, err)
+	}
+
+	return resp, nil
+}
+
+func s3Error(resp *http.Response, op, key string) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s failed with status %d: %s", op, key, resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+func (s *S3Store) Read(owner, name string) ([]byte, error) {
+	key, err := s.key(owner, name)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp, "get", key)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3 object %s: %w", key, err)
+	}
+
+	return content, nil
+}
+
+func (s *S3Store) Write(owner, name string, content []byte) error {
+	key, err := s.key(owner, name)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodPut, key, nil, content, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "put", key)
+	}
+
+	return nil
+}
+
+func (s *S3Store) Delete(owner, name string) error {
+	key, err := s.key(owner, name)
+	if err != nil {
+		return err
+	}
+
+	// S3 happily deletes objects that don't exist, so check first
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+
+	resp, err = s.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "delete", key)
+	}
+
+	return nil
+}
+
+// There's no rename in S3; copy the object over and delete the original
+func (s *S3Store) Rename(owner, oldName, newName string) error {
+	oldKey, err := s.key(owner, oldName)
+	if err != nil {
+		return err
+	}
+
+	newKey, err := s.key(owner, newName)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+s.opts.Bucket+"/"+uriEncode(oldKey, false))
+
+	resp, err := s.do(http.MethodPut, newKey, nil, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "copy", oldKey)
+	}
+
+	return s.Delete(owner, oldName)
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) List(owner string) ([]string, error) {
+	all, err := s.list(owner, "")
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, name := range all {
+		// Same as FsStore, hidden entries (e.g. staged content) aren't listed
+		if strings.HasPrefix(name, ".") || strings.Contains(name, "/.") {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (s *S3Store) ListDir(owner, dir string) ([]string, error) {
+	if _, err := s.key(owner, dir); err != nil {
+		return nil, err
+	}
+
+	return s.list(owner, dir+"/")
+}
+
+// Lists the names of every object starting with `sub` in the owner's prefix
+func (s *S3Store) list(owner, sub string) ([]string, error) {
+	ownerPrefix := s.ownerPrefix(owner)
+	prefix := ownerPrefix + sub
+	names := []string{}
+	token := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error(resp, "list", prefix)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode s3 listing: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, ownerPrefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return names, nil
+}
+
+// Percent-encodes everything but unreserved characters, as SigV4 requires.
+// Slashes are kept as-is in object paths.
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+
+	return sb.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signs `req` with AWS Signature Version 4, covering the host and every
+// header already set on it
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + s3Service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, s3Service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, accessKey, scope, signedHeaders, signature,
+	))
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Hidden, so listings (and therefore fsck) never mistake staged content for notes
+const stagingDir = ".staging"
+
+// A change to a note's content that's kept out of the way until committed,
+// so that it can be made to line up with the note's database row
+type Staged struct {
+	owner     string
+	name      string
+	staged    string
+	isDelete  bool
+	committed bool
+}
+
+func stagingName() string {
+	return stagingDir + "/" + uuid.NewString()
+}
+
+// Staged content that's still around once nothing is being changed was left
+// behind by a change that was interrupted
+func ListStaged(owner string) ([]string, error) {
+	return Store.ListDir(owner, stagingDir)
+}
+
+// Writes `content` to a staging location; Commit moves it into place
+func StageWrite(owner, name string, content []byte) (*Staged, error) {
+	s := &Staged{owner: owner, name: name, staged: stagingName()}
+
+	err := Store.Write(owner, s.staged, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage note: %w", err)
+	}
+
+	return s, nil
+}
+
+// Moves the note to a staging location; Commit removes it for good
+func StageDelete(owner, name string) (*Staged, error) {
+	s := &Staged{owner: owner, name: name, staged: stagingName(), isDelete: true}
+
+	err := Store.Rename(owner, name, s.staged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage note deletion: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *Staged) Commit() error {
+	var err error
+
+	if s.isDelete {
+		err = Store.Delete(s.owner, s.staged)
+	} else {
+		err = Store.Rename(s.owner, s.staged, s.name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to commit staged note: %w", err)
+	}
+
+	s.committed = true
+	return nil
+}
+
+// Undoes the change, including after a successful Commit of a write
+func (s *Staged) Rollback() error {
+	var err error
+
+	switch {
+	case s.isDelete && s.committed:
+		return fmt.Errorf("can't roll back a committed deletion")
+	case s.isDelete:
+		err = Store.Rename(s.owner, s.staged, s.name)
+	case s.committed:
+		err = Store.Delete(s.owner, s.name)
+	default:
+		err = Store.Delete(s.owner, s.staged)
+	}
+
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("failed to roll back staged note: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/musannif-md/musannif/internal/config"
+)
+
+const (
+	BackendFs = "fs"
+	BackendS3 = "s3"
+)
+
+// Hidden, like staged content, so attachments are never taken for notes
+const attachmentDir = ".attachments"
+
+var ErrNotExist = errors.New("note doesn't exist")
+
+// Where an attachment lives in its note owner's namespace
+func AttachmentName(id string) string {
+	return attachmentDir + "/" + id
+}
+
+// The ids of every attachment file in the owner's namespace
+func ListAttachments(owner string) ([]string, error) {
+	names, err := Store.ListDir(owner, attachmentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, attachmentDir+"/")
+	}
+
+	return names, nil
+}
+
+// Where note contents live. Names are relative to the owner's namespace and
+// may contain forward slashes.
+type NoteStore interface {
+	Read(owner, name string) ([]byte, error)
+	Write(owner, name string, content []byte) error
+	Delete(owner, name string) error
+	Rename(owner, oldName, newName string) error
+	List(owner string) ([]string, error)
+	// Lists every file under `dir`, which may be hidden; names are relative
+	// to the owner's namespace, as for List
+	ListDir(owner, dir string) ([]string, error)
+}
+
+var Store NoteStore
+
+func Initialize(cfg *config.AppConfig) error {
+	switch cfg.Storage.Backend {
+	case "", BackendFs:
+		Store = NewFsStore(cfg.App.NoteDirectory)
+
+	case BackendS3:
+		if cfg.Git.Enabled {
+			return fmt.Errorf("git storage requires the %q backend", BackendFs)
+		}
+
+		s3Cfg := cfg.Storage.S3
+		if s3Cfg.Endpoint == "" || s3Cfg.Bucket == "" {
+			return fmt.Errorf("s3 backend requires an endpoint and a bucket")
+		}
+
+		Store = NewS3Store(S3Options{
+			Endpoint:  s3Cfg.Endpoint,
+			Region:    s3Cfg.Region,
+			Bucket:    s3Cfg.Bucket,
+			Prefix:    s3Cfg.Prefix,
+			AccessKey: s3Cfg.AccessKey,
+			SecretKey: s3Cfg.SecretKey,
+		})
+
+	default:
+		return fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+
+	return nil
+}
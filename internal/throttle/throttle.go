@@ -0,0 +1,103 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Slows down repeated failures for a key (an IP, an account, ...): the first
+// few are free, each after that doubles the wait before the next attempt, and
+// enough of them lock the key out for a while. Keys are forgotten once they've
+// gone without failures for long enough.
+type Limiter struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type Options struct {
+	FreeAttempts int           // failures allowed before waiting kicks in
+	BaseDelay    time.Duration // wait after the first failure past the free ones
+	MaxDelay     time.Duration
+
+	LockoutAfter int           // failures after which the key is locked out
+	LockoutFor   time.Duration // counted from the last failure
+
+	Window time.Duration // failures are forgotten after this long without another
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+}
+
+func New(opts Options) *Limiter {
+	return &Limiter{
+		opts:    opts,
+		entries: make(map[string]*entry),
+	}
+}
+
+// How long `key` has to wait before its next attempt; zero if it can go ahead
+func (l *Limiter) Wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+
+	if now.Sub(e.lastFailure) > l.opts.Window {
+		delete(l.entries, key)
+		return 0
+	}
+
+	var delay time.Duration
+
+	switch {
+	case l.opts.LockoutAfter > 0 && e.failures >= l.opts.LockoutAfter:
+		delay = l.opts.LockoutFor
+
+	case e.failures >= l.opts.FreeAttempts:
+		delay = l.opts.BaseDelay
+		for i := l.opts.FreeAttempts; i < e.failures && delay < l.opts.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, l.opts.MaxDelay)
+	}
+
+	return max(e.lastFailure.Add(delay).Sub(now), 0)
+}
+
+func (l *Limiter) Fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Prune now and then, so that keys that never come back don't pile up
+	if len(l.entries) > 10000 {
+		for k, e := range l.entries {
+			if now.Sub(e.lastFailure) > l.opts.Window {
+				delete(l.entries, k)
+			}
+		}
+	}
+
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.lastFailure) > l.opts.Window {
+		e = &entry{}
+		l.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+}
+
+// Forgets about `key`'s failures
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
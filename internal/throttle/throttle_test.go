@@ -0,0 +1,47 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Options{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     4 * time.Second,
+		LockoutAfter: 6,
+		LockoutFor:   time.Minute,
+		Window:       time.Hour,
+	})
+
+	now := time.Unix(1000, 0)
+
+	expected := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Minute}
+	for i, wait := range expected {
+		if got := l.Wait("k", now); got != wait {
+			t.Errorf("after %d failures: expected to wait %s, got %s", i, wait, got)
+		}
+		l.Fail("k", now)
+	}
+
+	if got := l.Wait("k", now.Add(59*time.Second)); got != time.Second {
+		t.Errorf("expected lockout to count from the last failure, got %s", got)
+	}
+
+	if got := l.Wait("other", now); got != 0 {
+		t.Errorf("expected other keys to be unaffected, got %s", got)
+	}
+
+	if got := l.Wait("k", now.Add(2*time.Hour)); got != 0 {
+		t.Errorf("expected failures to be forgotten after the window, got %s", got)
+	}
+
+	l.Fail("k", now)
+	l.Fail("k", now)
+	l.Fail("k", now)
+	l.Reset("k")
+	if got := l.Wait("k", now); got != 0 {
+		t.Errorf("expected reset to clear failures, got %s", got)
+	}
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Sets API tokens apart from JWTs, and makes them easy to spot in leaked
+// config and logs
+const ApiTokenPrefix = "msn_"
+
+// What an API token may be used for. Routes that manage the account itself
+// (passwords, tokens, ...) can't be called with API tokens at all.
+const (
+	ScopeNotesRead  = "notes:read"  // fetch notes and anything derived from them
+	ScopeNotesWrite = "notes:write" // create, change and delete notes, attachments, tags and templates
+	ScopePublish    = "publish"     // change what's on the user's public site
+)
+
+var Scopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopePublish}
+
+func GenerateApiToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return ApiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, ApiTokenPrefix)
+}
+
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+
+	for _, s := range scopes {
+		if !slices.Contains(Scopes, s) {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrArchiveTooLarge = errors.New("archive contents too large")
+
+type ArchiveFile struct {
+	Name    string // slash-separated, relative to the archive's root
+	Content []byte
+}
+
+// Skips what editors and archivers leave around, e.g. `.obsidian/` or
+// `__MACOSX/`
+func ignoredArchivePath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readArchiveFile(files []ArchiveFile, name string, r io.Reader, remaining *int64) ([]ArchiveFile, error) {
+	// Entries can't escape the root, however they're named
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" || ignoredArchivePath(name) {
+		return files, nil
+	}
+
+	content, err := io.ReadAll(io.LimitReader(r, *remaining+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from archive: %w", name, err)
+	}
+
+	*remaining -= int64(len(content))
+	if *remaining < 0 {
+		return nil, ErrArchiveTooLarge
+	}
+
+	return append(files, ArchiveFile{Name: name, Content: content}), nil
+}
+
+func readZip(content []byte, remaining int64) ([]ArchiveFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+
+	files := []ArchiveFile{}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s in archive: %w", f.Name, err)
+		}
+
+		files, err = readArchiveFile(files, f.Name, rc, &remaining)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func readTar(r io.Reader, remaining int64) ([]ArchiveFile, error) {
+	tr := tar.NewReader(r)
+	files := []ArchiveFile{}
+
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		// Links and other special files are of no use here
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		files, err = readArchiveFile(files, h.Name, tr, &remaining)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Extracts the regular files in a zip, tar or gzipped tar archive, in the order
+// they appear in it. Fails with `ErrArchiveTooLarge` once they add up to more
+// than `maxSize` bytes.
+func ReadArchive(content []byte, maxSize int64) ([]ArchiveFile, error) {
+	switch {
+	case bytes.HasPrefix(content, []byte("PK\x03\x04")), bytes.HasPrefix(content, []byte("PK\x05\x06")):
+		return readZip(content, maxSize)
+
+	case bytes.HasPrefix(content, []byte("\x1f\x8b")):
+		gr, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gr.Close()
+
+		return readTar(gr, maxSize)
+
+	default:
+		return readTar(bytes.NewReader(content), maxSize)
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+func TestReadArchive(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range map[string]string{
+		"../../escape.md":        "# Escape",
+		"vault/.obsidian/a.json": "{}",
+		"vault/Note.md":          "# Note",
+	} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.WriteHeader(&tar.Header{Name: "vault/link.md", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink})
+	tw.Close()
+	gw.Close()
+
+	files, err := ReadArchive(buf.Bytes(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, f := range files {
+		names[f.Name] = true
+	}
+
+	if len(names) != 2 || !names["escape.md"] || !names["vault/Note.md"] {
+		t.Errorf("unexpected files: %v", names)
+	}
+
+	if _, err = ReadArchive(buf.Bytes(), 8); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("expected archive to be too large, got %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+
+	// Comparing takes time in proportion to the length of the texts times how
+	// much they differ, so both are bounded
+	maxDiffLines = 100_000
+	maxDiffEdits = 4_000
+)
+
+var ErrDiffTooLarge = errors.New("texts are too large or too different to compare")
+
+const (
+	opEqual  = ' '
+	opDelete = '-'
+	opInsert = '+'
+)
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+type differ struct {
+	a, b   []string
+	ops    []diffOp
+	vf, vb []int // furthest reaching paths, forwards and backwards
+}
+
+// Myers' O(ND) diff over lines, in linear space; returns the edit script from
+// `a` to `b`, or ErrDiffTooLarge if the texts are too long or too different
+// to be compared cheaply
+func diffLines(a, b []string) ([]diffOp, error) {
+	if len(a)+len(b) > maxDiffLines {
+		return nil, ErrDiffTooLarge
+	}
+
+	size := len(a) + len(b) + 2
+	d := &differ{
+		a:   a,
+		b:   b,
+		ops: make([]diffOp, 0, len(a)+len(b)),
+		vf:  make([]int, 2*size+1),
+		vb:  make([]int, 2*size+1),
+	}
+
+	if !d.diff(0, len(a), 0, len(b), maxDiffEdits) {
+		return nil, ErrDiffTooLarge
+	}
+
+	return d.ops, nil
+}
+
+// Appends the edit script from a[left:right] to b[top:bottom], splitting the
+// texts at the middle of a shortest edit path. Only the outermost call has a
+// limit on the edit distance (a negative one means none), since the rest can
+// only be shorter. Reports false if the limit was exceeded.
+func (d *differ) diff(left, right, top, bottom, limit int) bool {
+	// Common lines at either end are left out of the search
+	for left < right && top < bottom && d.a[left] == d.b[top] {
+		d.ops = append(d.ops, diffOp{opEqual, d.a[left]})
+		left++
+		top++
+	}
+
+	suffix := 0
+	for left < right && top < bottom && d.a[right-1] == d.b[bottom-1] {
+		right--
+		bottom--
+		suffix++
+	}
+
+	switch {
+	case left == right:
+		for _, line := range d.b[top:bottom] {
+			d.ops = append(d.ops, diffOp{opInsert, line})
+		}
+
+	case top == bottom:
+		for _, line := range d.a[left:right] {
+			d.ops = append(d.ops, diffOp{opDelete, line})
+		}
+
+	default:
+		x, y, u, v, ok := d.middleSnake(left, right, top, bottom, limit)
+		if !ok {
+			return false
+		}
+
+		d.diff(left, x, top, y, -1)
+
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, diffOp{opEqual, d.a[x]})
+		}
+
+		d.diff(u, right, v, bottom, -1)
+	}
+
+	for _, line := range d.a[right : right+suffix] {
+		d.ops = append(d.ops, diffOp{opEqual, line})
+	}
+
+	return true
+}
+
+// Finds the snake (x, y) -> (u, v) in the middle of a shortest edit path
+// through a[left:right] and b[top:bottom], by searching from both ends until
+// the paths meet. Both texts must be non-empty and differ at either end.
+func (d *differ) middleSnake(left, right, top, bottom, limit int) (x, y, u, v int, ok bool) {
+	n, m := right-left, bottom-top
+	delta := n - m
+	odd := delta%2 != 0
+
+	// Diagonals are offset so that they index into the path arrays; forward
+	// ones are numbered from (left, top), backward ones from (right, bottom)
+	offset := len(d.vf) / 2
+	d.vf[offset+1] = 0
+	d.vb[offset+1] = 0
+
+	for D := 0; D <= (n+m+1)/2; D++ {
+		if limit >= 0 && 2*D > limit {
+			return 0, 0, 0, 0, false
+		}
+
+		for k := -D; k <= D; k += 2 {
+			// How far along `a` the path on this diagonal gets
+			var px int
+			if k == -D || (k != D && d.vf[offset+k-1] < d.vf[offset+k+1]) {
+				px = d.vf[offset+k+1]
+			} else {
+				px = d.vf[offset+k-1] + 1
+			}
+
+			px1, py1 := px, px-k
+			for px1 < n && py1 < m && d.a[left+px1] == d.b[top+py1] {
+				px1++
+				py1++
+			}
+			d.vf[offset+k] = px1
+
+			// The backward path on the same diagonal has been searched one
+			// step less
+			c := delta - k
+			if odd && c >= -(D-1) && c <= D-1 && px1+d.vb[offset+c] >= n {
+				return left + px, top + px - k, left + px1, top + py1, true
+			}
+		}
+
+		for c := -D; c <= D; c += 2 {
+			// How far back along `a` the path on this diagonal gets
+			var qx int
+			if c == -D || (c != D && d.vb[offset+c-1] < d.vb[offset+c+1]) {
+				qx = d.vb[offset+c+1]
+			} else {
+				qx = d.vb[offset+c-1] + 1
+			}
+
+			qx1, qy1 := qx, qx-c
+			for qx1 < n && qy1 < m && d.a[right-1-qx1] == d.b[bottom-1-qy1] {
+				qx1++
+				qy1++
+			}
+			d.vb[offset+c] = qx1
+
+			k := delta - c
+			if !odd && k >= -D && k <= D && qx1+d.vf[offset+k] >= n {
+				return right - qx1, bottom - qy1, right - qx, bottom - (qx - c), true
+			}
+		}
+	}
+
+	// Paths always meet by the time each has gone half the way
+	panic("diff: no middle snake")
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Produces a unified diff (as `diff -u` would) between two texts. Returns an
+// empty string if they're identical, and ErrDiffTooLarge if they're too large
+// or too different to compare.
+func UnifiedDiff(from, to, fromLabel, toLabel string) (string, error) {
+	ops, err := diffLines(splitLines(from), splitLines(to))
+	if err != nil {
+		return "", err
+	}
+
+	// Line offsets in `from` and `to` before each op
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	changes := []int{}
+
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+
+		if op.kind != opDelete {
+			bPos[i+1]++
+		}
+		if op.kind != opInsert {
+			aPos[i+1]++
+		}
+		if op.kind != opEqual {
+			changes = append(changes, i)
+		}
+	}
+
+	if len(changes) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromLabel, toLabel)
+
+	for i := 0; i < len(changes); {
+		// Merge changes whose context would overlap into one hunk
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContextLines {
+			j++
+		}
+
+		start := max(changes[i]-diffContextLines, 0)
+		end := min(changes[j]+diffContextLines+1, len(ops))
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[end]-aPos[start]),
+			hunkRange(bPos[start], bPos[end]-bPos[start]),
+		)
+
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = j + 1
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	to := "a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\n"
+
+	expected := `--- v1
++++ v2
+@@ -1,6 +1,6 @@
+ a
+ b
+-c
++C
+ d
+ e
+ f
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+
+	got, err := UnifiedDiff(from, to, "v1", "v2")
+	if err != nil || got != expected {
+		t.Errorf("unexpected diff:\n%s", got)
+	}
+
+	if got, _ = UnifiedDiff(from, from, "v1", "v2"); got != "" {
+		t.Error("expected no diff between identical texts")
+	}
+
+	got, _ = UnifiedDiff("", "x", "v1", "v2")
+	expected = "--- v1\n+++ v2\n@@ -0,0 +1 @@\n+x\n\\ No newline at end of file\n"
+	if got != expected {
+		t.Errorf("unexpected diff:\n%s", got)
+	}
+}
+
+// Length of the longest common subsequence, the slow way
+func lcsLength(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(cur[j], prev[j+1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for range 2000 {
+		a, b := randomLines(), randomLines()
+
+		ops, err := diffLines(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var gotA, gotB []string
+		edits := 0
+
+		for _, op := range ops {
+			if op.kind != opInsert {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != opDelete {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != opEqual {
+				edits++
+			}
+		}
+
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("edit script doesn't turn %v into %v: %v", a, b, ops)
+		}
+
+		if minimal := len(a) + len(b) - 2*lcsLength(a, b); edits != minimal {
+			t.Fatalf("expected %d edits from %v to %v, got %d", minimal, a, b, edits)
+		}
+	}
+}
+
+func TestUnifiedDiffLargeInputs(t *testing.T) {
+	numbered := func(prefix string, n int) string {
+		var sb strings.Builder
+		for i := range n {
+			fmt.Fprintf(&sb, "%s %d\n", prefix, i)
+		}
+		return sb.String()
+	}
+
+	// Long texts with a few changes are compared as usual
+	from := numbered("line", 20_000)
+	to := strings.Replace(from, "line 10000\n", "changed\n", 1) + "appended\n"
+
+	got, err := UnifiedDiff(from, to, "v1", "v2")
+	if err != nil || !strings.Contains(got, "-line 10000\n+changed\n") || !strings.Contains(got, "+appended\n") {
+		t.Errorf("unexpected diff of long texts: %v\n%s", err, got)
+	}
+
+	// Ones that have nothing in common would take too long
+	if _, err = UnifiedDiff(from, numbered("other", 20_000), "v1", "v2"); !errors.Is(err, ErrDiffTooLarge) {
+		t.Errorf("expected completely different texts to be refused, got %v", err)
+	}
+
+	if _, err = UnifiedDiff(numbered("line", maxDiffLines), "x\n", "v1", "v2"); !errors.Is(err, ErrDiffTooLarge) {
+		t.Errorf("expected too many lines to be refused, got %v", err)
+	}
+}
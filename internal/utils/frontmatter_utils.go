@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const frontMatterDelim = "---"
+
+// Splits a leading YAML front matter block (between two `---` lines) off of a
+// note. `ok` is false if there's none.
+func splitFrontMatter(content []byte) (frontMatter, body []byte, ok bool) {
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+
+	rest, found := bytes.CutPrefix(content, []byte(frontMatterDelim+"\n"))
+	if !found {
+		return nil, content, false
+	}
+
+	for offset := 0; offset <= len(rest); {
+		end := bytes.IndexByte(rest[offset:], '\n')
+		if end == -1 {
+			end = len(rest) - offset
+		}
+
+		line := rest[offset : offset+end]
+		if string(line) == frontMatterDelim || string(line) == "..." {
+			return rest[:offset], rest[min(offset+end+1, len(rest)):], true
+		}
+
+		offset += end + 1
+	}
+
+	return nil, content, false
+}
+
+// Returns a note without its front matter, if it has any
+func StripFrontMatter(content []byte) []byte {
+	_, body, _ := splitFrontMatter(content)
+	return body
+}
+
+func propertyString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), true
+	case time.Time:
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format(time.DateOnly), true
+		}
+		return v.Format(time.RFC3339), true
+	default:
+		return "", false
+	}
+}
+
+// Returns the properties in a note's front matter. Scalar values become
+// strings and lists of scalars become []string; anything else (nested maps,
+// nulls) is left out.
+func ParseFrontMatter(content []byte) (map[string]any, error) {
+	properties := map[string]any{}
+
+	frontMatter, _, ok := splitFrontMatter(content)
+	if !ok {
+		return properties, nil
+	}
+
+	var raw map[string]any
+	err := yaml.Unmarshal(frontMatter, &raw)
+	if err != nil {
+		return properties, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	for key, value := range raw {
+		if s, ok := propertyString(value); ok {
+			properties[key] = s
+			continue
+		}
+
+		list, ok := value.([]any)
+		if !ok {
+			continue
+		}
+
+		values := []string{}
+		for _, item := range list {
+			if s, ok := propertyString(item); ok {
+				values = append(values, s)
+			}
+		}
+		properties[key] = values
+	}
+
+	return properties, nil
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	content := "---\r\ntitle: Design doc\r\ntags: [backend, storage]\r\nstatus: draft\r\npriority: 2\r\ndue: 2025-01-31\r\nowner:\r\n  name: nested\r\n---\r\n# Body\r\n---\r\n"
+
+	properties, err := ParseFrontMatter([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]any{
+		"title":    "Design doc",
+		"tags":     []string{"backend", "storage"},
+		"status":   "draft",
+		"priority": "2",
+		"due":      "2025-01-31",
+	}
+
+	if !reflect.DeepEqual(properties, expected) {
+		t.Errorf("unexpected properties: %#v", properties)
+	}
+
+	for _, content := range []string{"# No front matter\n---\n", "---\nunterminated: true\n"} {
+		properties, err = ParseFrontMatter([]byte(content))
+		if err != nil || len(properties) != 0 {
+			t.Errorf("expected no properties for %q, got %v (%v)", content, properties, err)
+		}
+	}
+
+	if _, err = ParseFrontMatter([]byte("---\n: [\n---\n")); err == nil {
+		t.Error("expected invalid front matter to be reported")
+	}
+}
@@ -5,51 +5,192 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	tokenIssuer = "Markdocs"
+
+	tokenUseAccess  = "access"
+	tokenUseRefresh = "refresh"
+	tokenUseMfa     = "mfa"
+
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	mfaTokenTTL = 5 * time.Minute
 )
 
 var (
 	accessSecret  []byte
 	refreshSecret []byte
+
+	accessTokenTTL  = defaultAccessTokenTTL
+	refreshTokenTTL = defaultRefreshTokenTTL
+
+	revocationChecker func(*CustomClaims) error
 )
 
 type CustomClaims struct {
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"` // access tokens only; role changes show up once the token is refreshed
+	Use      string `json:"use"`            // keeps refresh tokens from passing as access tokens, and vice versa
 	jwt.RegisteredClaims
 }
 
+type RefreshClaims struct {
+	CustomClaims
+	Family string `json:"family"` // tokens rotated from the same login
+}
+
+func init() {
+	// Token cutoffs are kept to the millisecond, so that logging out
+	// everywhere doesn't also revoke a token issued right after within the
+	// same second. Tokens are issued in whole milliseconds; the finer
+	// precision keeps parsing, which goes through a float, from losing one.
+	jwt.TimePrecision = time.Microsecond
+}
+
 func SetJwtKeys(access, refresh string) {
 	accessSecret = []byte(access)
 	refreshSecret = []byte(refresh)
 }
 
-func GenerateToken(userID string) (string, error) {
-	claims := CustomClaims{
-		Username: userID,
+// Has `ValidateToken` turn down tokens for which `check` returns an error,
+// e.g. those that have been logged out
+func SetRevocationChecker(check func(*CustomClaims) error) {
+	revocationChecker = check
+}
+
+// Zero values keep the defaults
+func SetTokenLifetimes(access, refresh time.Duration) {
+	if access > 0 {
+		accessTokenTTL = access
+	}
+
+	if refresh > 0 {
+		refreshTokenTTL = refresh
+	}
+}
+
+func newClaims(username, use string, ttl time.Duration) CustomClaims {
+	now := time.Now().Truncate(time.Millisecond)
+
+	return CustomClaims{
+		Username: username,
+		Use:      use,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "Markdocs",
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    tokenIssuer,
 		},
 	}
+}
+
+// Issues a short-lived access token
+func GenerateToken(username, role string) (string, error) {
+	claims := newClaims(username, tokenUseAccess, accessTokenTTL)
+	claims.Role = role
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(accessSecret)
 }
 
-func ValidateToken(tokenString string) (*CustomClaims, error) {
+// Issues a refresh token belonging to `family`, returning it along with its
+// claims so that it can be recorded
+func GenerateRefreshToken(username, family string) (string, *RefreshClaims, error) {
+	claims := &RefreshClaims{
+		CustomClaims: newClaims(username, tokenUseRefresh, refreshTokenTTL),
+		Family:       family,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(refreshSecret)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
+
+// Issues a token proving that `username` got their password right, to be
+// traded for an access token along with a second factor
+func GenerateMfaToken(username string) (string, error) {
+	claims := newClaims(username, tokenUseMfa, mfaTokenTTL)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(accessSecret)
+}
+
+func parseToken(tokenString string, claims jwt.Claims, secret []byte) error {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
-		&CustomClaims{},
-		func(token *jwt.Token) (any, error) { return accessSecret, nil },
+		claims,
+		func(token *jwt.Token) (any, error) { return secret, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
 	)
 
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
+
+func ValidateToken(tokenString string) (*CustomClaims, error) {
+	claims := &CustomClaims{}
+
+	err := parseToken(tokenString, claims, accessSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Use != tokenUseAccess {
+		return nil, fmt.Errorf("not an access token")
+	}
+
+	if revocationChecker != nil {
+		if err = revocationChecker(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
+// Only checks the token itself; whether it's been used or revoked is up to
+// the caller
+func ValidateRefreshToken(tokenString string) (*RefreshClaims, error) {
+	claims := &RefreshClaims{}
+
+	err := parseToken(tokenString, claims, refreshSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Use != tokenUseRefresh || claims.Family == "" {
+		return nil, fmt.Errorf("not a refresh token")
+	}
+
+	return claims, nil
+}
+
+func ValidateMfaToken(tokenString string) (*CustomClaims, error) {
+	claims := &CustomClaims{}
+
+	err := parseToken(tokenString, claims, accessSecret)
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		return claims, nil
+	if claims.Use != tokenUseMfa {
+		return nil, fmt.Errorf("not a two-factor authentication token")
 	}
 
-	return nil, fmt.Errorf("unknown claims type (invalid key), cannot proceed")
+	return claims, nil
 }
@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	LinkKindWiki     = "wiki"
+	LinkKindMarkdown = "markdown"
+
+	noteExt = ".md"
+)
+
+var (
+	// [[Target]], [[Target#Heading]], [[Target|Alias]], and embeds (![[Target]])
+	wikiLinkRe = regexp.MustCompile(`\[\[([^\[\]|#]+)(#[^\[\]|]*)?(\|[^\[\]]*)?\]\]`)
+
+	// [text](target) and [text](<target> "title"); only targets that turn out to
+	// be relative paths to notes count
+	mdLinkRe = regexp.MustCompile(`\[[^\]]*\]\(\s*(<[^>]+>|[^()\s]+)(\s+"[^"]*")?\s*\)`)
+
+	fenceRe = regexp.MustCompile("^\\s*(```|~~~)")
+
+	// ![alt](target "title")
+	mdImageRe = regexp.MustCompile(`!\[([^\]]*)\]\(\s*(<[^>]+>|[^()\s]+)(\s+"[^"]*")?\s*\)`)
+
+	// ![[file.png]] and ![[file.png|300]]
+	wikiEmbedRe = regexp.MustCompile(`!\[\[([^\[\]|#]+)(#[^\[\]|]*)?(\|[^\[\]]*)?\]\]`)
+)
+
+type NoteLink struct {
+	Target string // note name, with extension
+	Kind   string
+}
+
+// Calls `fn` on the parts of a note that aren't code (fenced blocks or inline
+// code spans), replacing them with what it returns
+func mapProse(content string, fn func(string) string) string {
+	lines := strings.SplitAfter(content, "\n")
+	inFence := false
+
+	for i, line := range lines {
+		if fenceRe.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+
+		if inFence {
+			continue
+		}
+
+		parts := strings.Split(line, "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = fn(parts[j])
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+
+	return strings.Join(lines, "")
+}
+
+func wikiTarget(target string) (string, bool) {
+	target = strings.Trim(strings.TrimSpace(target), "/")
+	if target == "" {
+		return "", false
+	}
+
+	if !strings.HasSuffix(target, noteExt) {
+		target += noteExt
+	}
+
+	target = path.Clean(target)
+	if strings.HasPrefix(target, "../") {
+		return "", false
+	}
+
+	return target, true
+}
+
+// Resolves a markdown link target relative to the note it's in
+func markdownTarget(source, target string) (string, bool) {
+	resolved, ok := markdownPath(source, target)
+	if !ok || !strings.HasSuffix(resolved, noteExt) {
+		return "", false
+	}
+
+	return resolved, true
+}
+
+// Like markdownTarget, for links to any file
+func markdownPath(source, target string) (string, bool) {
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	target, _, _ = strings.Cut(target, "#")
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+
+	resolved := path.Join(path.Dir(source), u.Path)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+
+	return resolved, true
+}
+
+// Returns the notes `source` links to, via wiki-links or relative markdown
+// links, in order of first appearance
+func ParseLinks(source, content string) []NoteLink {
+	links := []NoteLink{}
+	seen := map[NoteLink]bool{}
+
+	add := func(target, kind string) {
+		l := NoteLink{Target: target, Kind: kind}
+		if !seen[l] {
+			seen[l] = true
+			links = append(links, l)
+		}
+	}
+
+	mapProse(content, func(s string) string {
+		for _, m := range wikiLinkRe.FindAllStringSubmatch(s, -1) {
+			if target, ok := wikiTarget(m[1]); ok {
+				add(target, LinkKindWiki)
+			}
+		}
+
+		for _, m := range mdLinkRe.FindAllStringSubmatch(s, -1) {
+			if target, ok := markdownTarget(source, m[1]); ok {
+				add(target, LinkKindMarkdown)
+			}
+		}
+
+		return s
+	})
+
+	return links
+}
+
+func relativeLink(source, target string) string {
+	dir := path.Dir(source)
+	up := ""
+
+	for dir != "." && !strings.HasPrefix(target, dir+"/") {
+		dir = path.Dir(dir)
+		up += "../"
+	}
+
+	if dir != "." {
+		target = strings.TrimPrefix(target, dir+"/")
+	}
+
+	return strings.ReplaceAll(up+target, " ", "%20")
+}
+
+// Points every link in `source` that leads to `oldName` at `newName` instead,
+// keeping headings, aliases and titles. Reports whether anything changed.
+func RewriteLinks(source, content, oldName, newName string) (string, bool) {
+	return RewriteRenamedLinks(source, content, map[string]string{oldName: newName})
+}
+
+// Like RewriteLinks, for several notes at once; `renamed` maps old names to
+// new ones. Old names needn't end in .md, though only markdown links can
+// lead to those that don't.
+func RewriteRenamedLinks(source, content string, renamed map[string]string) (string, bool) {
+	changed := false
+
+	rewritten := mapProse(content, func(s string) string {
+		s = wikiLinkRe.ReplaceAllStringFunc(s, func(link string) string {
+			m := wikiLinkRe.FindStringSubmatch(link)
+			target, ok := wikiTarget(m[1])
+			if !ok {
+				return link
+			}
+
+			newName, ok := renamed[target]
+			if !ok {
+				return link
+			}
+
+			changed = true
+			return "[[" + strings.TrimSuffix(newName, noteExt) + m[2] + m[3] + "]]"
+		})
+
+		return mdLinkRe.ReplaceAllStringFunc(s, func(link string) string {
+			m := mdLinkRe.FindStringSubmatchIndex(link)
+			target := link[m[2]:m[3]]
+
+			resolved, ok := markdownPath(source, target)
+			if !ok {
+				return link
+			}
+
+			newName, ok := renamed[resolved]
+			if !ok {
+				return link
+			}
+
+			anchor := ""
+			if _, a, found := strings.Cut(strings.Trim(target, "<>"), "#"); found {
+				anchor = "#" + a
+			}
+
+			changed = true
+			return link[:m[2]] + relativeLink(source, newName) + anchor + link[m[3]:]
+		})
+	})
+
+	return rewritten, changed
+}
+
+// Points the images (and other embedded files) in a note at whatever
+// `resolve` returns for their targets, leaving those it doesn't know about
+// alone. Wiki-style embeds become markdown images.
+func RewriteEmbeds(content string, resolve func(target string) (string, bool)) string {
+	return mapProse(content, func(s string) string {
+		s = mdImageRe.ReplaceAllStringFunc(s, func(link string) string {
+			m := mdImageRe.FindStringSubmatchIndex(link)
+			target := strings.TrimSuffix(strings.TrimPrefix(link[m[4]:m[5]], "<"), ">")
+
+			if unescaped, err := url.PathUnescape(target); err == nil {
+				target = unescaped
+			}
+
+			resolved, ok := resolve(target)
+			if !ok {
+				return link
+			}
+
+			return link[:m[4]] + resolved + link[m[5]:]
+		})
+
+		return wikiEmbedRe.ReplaceAllStringFunc(s, func(link string) string {
+			m := wikiEmbedRe.FindStringSubmatch(link)
+			target := strings.TrimSpace(m[1])
+
+			// Embedded notes are left to whoever renders them
+			if path.Ext(target) == "" || path.Ext(target) == noteExt {
+				return link
+			}
+
+			resolved, ok := resolve(target)
+			if !ok {
+				return link
+			}
+
+			return "![" + path.Base(target) + "](" + resolved + ")"
+		})
+	})
+}
+
+// Calls `fn` for every link to another note in `source` (embeds excluded),
+// replacing the link with what it returns. `anchor` is the heading linked to,
+// if any, and `text` what the link shows.
+func MapNoteLinks(source, content string, fn func(target, anchor, text string) string) string {
+	return mapProse(content, func(s string) string {
+		var sb strings.Builder
+		last := 0
+
+		for _, m := range wikiLinkRe.FindAllStringSubmatchIndex(s, -1) {
+			if m[0] > 0 && s[m[0]-1] == '!' {
+				continue
+			}
+
+			target, ok := wikiTarget(s[m[2]:m[3]])
+			if !ok {
+				continue
+			}
+
+			anchor, text := "", strings.TrimSpace(s[m[2]:m[3]])
+			if m[4] != -1 {
+				anchor = s[m[4]+1 : m[5]]
+			}
+			if m[6] != -1 {
+				text = s[m[6]+1 : m[7]]
+			}
+
+			sb.WriteString(s[last:m[0]])
+			sb.WriteString(fn(target, anchor, text))
+			last = m[1]
+		}
+
+		sb.WriteString(s[last:])
+		s = sb.String()
+
+		sb.Reset()
+		last = 0
+
+		for _, m := range mdLinkRe.FindAllStringSubmatchIndex(s, -1) {
+			if m[0] > 0 && s[m[0]-1] == '!' {
+				continue
+			}
+
+			raw := s[m[2]:m[3]]
+			target, ok := markdownTarget(source, raw)
+			if !ok {
+				continue
+			}
+
+			anchor := ""
+			if _, a, found := strings.Cut(strings.Trim(raw, "<>"), "#"); found {
+				anchor = a
+			}
+
+			text := s[m[0]+1 : strings.Index(s[m[0]:], "](")+m[0]]
+
+			sb.WriteString(s[last:m[0]])
+			sb.WriteString(fn(target, anchor, text))
+			last = m[1]
+		}
+
+		sb.WriteString(s[last:])
+		return sb.String()
+	})
+}
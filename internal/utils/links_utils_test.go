@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const linkingNote = "See [[Other Note]], [[folder/Deep#Intro|the intro]] and ![[Other Note]].\n" +
+	"Also [this](../Top%20Level.md#part), [site](https://example.com/x.md) and ![img](pic.png).\n" +
+	"```\n[[In Code]]\n```\n" +
+	"Inline `[[Also Code]]` isn't a link but [[Sibling]] is, as is [that](Sibling.md).\n"
+
+func TestParseLinks(t *testing.T) {
+	links := ParseLinks("folder/note.md", linkingNote)
+
+	expected := []NoteLink{
+		{"Other Note.md", LinkKindWiki},
+		{"folder/Deep.md", LinkKindWiki},
+		{"Top Level.md", LinkKindMarkdown},
+		{"Sibling.md", LinkKindWiki},
+		{"folder/Sibling.md", LinkKindMarkdown},
+	}
+
+	if !slices.Equal(links, expected) {
+		t.Errorf("unexpected links: %+v", links)
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	got, changed := RewriteLinks("folder/note.md", linkingNote, "Other Note.md", "Renamed.md")
+	if !changed {
+		t.Fatal("expected links to be rewritten")
+	}
+
+	expected := "See [[Renamed]], [[folder/Deep#Intro|the intro]] and ![[Renamed]].\n"
+	if got[:len(expected)] != expected {
+		t.Errorf("unexpected rewrite: %q", got)
+	}
+
+	got, _ = RewriteLinks("folder/note.md", linkingNote, "Top Level.md", "archive/Old Top.md")
+	expected = "Also [this](../archive/Old%20Top.md#part)"
+	if !slices.Contains(splitLines(got), expected+", [site](https://example.com/x.md) and ![img](pic.png).\n") {
+		t.Errorf("unexpected rewrite: %q", got)
+	}
+
+	if _, changed = RewriteLinks("folder/note.md", linkingNote, "In Code.md", "x.md"); changed {
+		t.Error("links in code shouldn't be rewritten")
+	}
+}
+
+func TestRewriteRenamedLinks(t *testing.T) {
+	got, changed := RewriteRenamedLinks("notes/a.md", "[b](b.markdown), [c](../c.MD#top) and [[b]]", map[string]string{
+		"notes/b.markdown": "notes/b.md",
+		"c.MD":             "c.md",
+	})
+
+	expected := "[b](b.md), [c](../c.md#top) and [[b]]"
+	if !changed || got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestMapNoteLinks(t *testing.T) {
+	got := MapNoteLinks("folder/note.md", linkingNote, func(target, anchor, text string) string {
+		return "<" + target + "#" + anchor + "|" + text + ">"
+	})
+
+	for _, expected := range []string{
+		"See <Other Note.md#|Other Note>, <folder/Deep.md#Intro|the intro> and ![[Other Note]].",
+		"Also <Top Level.md#part|this>, [site](https://example.com/x.md) and ![img](pic.png).",
+		"```\n[[In Code]]\n```",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected %q in %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultMinPasswordLength = 10
+
+	// bcrypt only looks at the first 72 bytes, and a 16 byte salt is appended
+	// to every password before hashing
+	maxPasswordBytes = 72 - 16
+)
+
+var (
+	ErrWeakPassword = errors.New("password doesn't meet the policy")
+
+	minPasswordLength = defaultMinPasswordLength
+)
+
+// Zero keeps the default
+func SetMinPasswordLength(n int) {
+	if n > 0 {
+		minPasswordLength = n
+	}
+}
+
+// Checks `password` against the password policy; errors wrap ErrWeakPassword
+// and say which rule was broken
+func ValidatePassword(username, password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("%w: must be at least %d characters long", ErrWeakPassword, minPasswordLength)
+	}
+
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes long", ErrWeakPassword, maxPasswordBytes)
+	}
+
+	var letters, others bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+
+	if !letters || !others {
+		return fmt.Errorf("%w: must contain letters as well as digits or symbols", ErrWeakPassword)
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: mustn't contain the username", ErrWeakPassword)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"short1", false},
+		{"onlyletterstoolong", false},
+		{"1234567890", false},
+		{"alice-rocks-2025", false}, // contains the username
+		{"correct horse battery", true},
+		{"kiwi-Fruit-7", true},
+		{"0123456789012345678901234567890123456789012345678901234567a", false}, // too long for bcrypt
+	}
+
+	for _, c := range cases {
+		err := ValidatePassword("Alice", c.password)
+		if c.valid && err != nil {
+			t.Errorf("expected %q to be accepted, got %v", c.password, err)
+		}
+		if !c.valid && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("expected %q to be rejected, got %v", c.password, err)
+		}
+	}
+}
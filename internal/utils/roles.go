@@ -0,0 +1,49 @@
+package utils
+
+import "slices"
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest"
+
+	// What users were created as before roles meant anything
+	roleLegacyUser = "user"
+)
+
+// What a role allows. Routes are guarded by these rather than by roles, so
+// that roles can change without touching the routes.
+const (
+	PermNotesRead   = "notes.read"
+	PermNotesWrite  = "notes.write"
+	PermPublish     = "notes.publish"
+	PermApiTokens   = "tokens.manage"
+	PermUsersManage = "users.manage"
+)
+
+var Roles = []string{RoleAdmin, RoleMember, RoleGuest}
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:  {PermNotesRead, PermNotesWrite, PermPublish, PermApiTokens, PermUsersManage},
+	RoleMember: {PermNotesRead, PermNotesWrite, PermPublish, PermApiTokens},
+	RoleGuest:  {PermNotesRead},
+}
+
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// Maps roles as stored to one of `Roles`; rows from before roles were
+// enforced say "user", which became "member"
+func NormalizeRole(role string) string {
+	if role == roleLegacyUser {
+		return RoleMember
+	}
+
+	return role
+}
+
+// Unknown roles have no permissions
+func HasPermission(role, perm string) bool {
+	return slices.Contains(rolePermissions[NormalizeRole(role)], perm)
+}
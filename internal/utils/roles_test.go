@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	cases := []struct {
+		role, perm string
+		allowed    bool
+	}{
+		{RoleAdmin, PermUsersManage, true},
+		{RoleMember, PermUsersManage, false},
+		{RoleMember, PermNotesWrite, true},
+		{"user", PermNotesWrite, true}, // legacy rows
+		{RoleGuest, PermNotesRead, true},
+		{RoleGuest, PermNotesWrite, false},
+		{RoleGuest, PermApiTokens, false},
+		{"", PermNotesRead, false},
+		{"superuser", PermNotesRead, false},
+	}
+
+	for _, c := range cases {
+		if got := HasPermission(c.role, c.perm); got != c.allowed {
+			t.Errorf("%q with %q: expected %v, got %v", c.role, c.perm, c.allowed, got)
+		}
+	}
+}
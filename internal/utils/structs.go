@@ -7,4 +7,138 @@ type NoteMetadata struct {
 	Name         string `json:"note_name"`
 	CreatedAt    string `json:"created_at"`    // unix time
 	LastModified string `json:"last_modified"` // unix time
+
+	// From the note's front matter; values are strings, or []string for lists
+	Properties map[string]any `json:"properties,omitempty"`
+	Tags       []string       `json:"tags,omitempty"`
+}
+
+type NoteVersion struct {
+	Id        string `json:"version_id"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"created_at"` // unix time
+	Hash      string `json:"hash"`       // sha256 of the content
+	Size      string `json:"size"`       // bytes
+}
+
+type NoteCommit struct {
+	Hash        string `json:"hash"`
+	Author      string `json:"author"`
+	CommittedAt string `json:"committed_at"` // unix time
+	Message     string `json:"message"`
+}
+
+type Attachment struct {
+	Id        string `json:"attachment_id"`
+	NoteName  string `json:"note_name"`
+	Filename  string `json:"filename"`
+	MimeType  string `json:"mime_type"`
+	Size      string `json:"size"`       // bytes
+	CreatedAt string `json:"created_at"` // unix time
+}
+
+type TagCount struct {
+	Name  string `json:"name"`
+	Count string `json:"count"` // number of notes with the tag
+}
+
+type BrokenLink struct {
+	Source string `json:"source"` // note the link is in
+	Target string `json:"target"` // note name it points at, which doesn't exist
+}
+
+type LinkGraphNode struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type LinkGraphEdge struct {
+	Source string `json:"source"` // node id
+	Target string `json:"target"` // node id
+}
+
+type LinkGraph struct {
+	Nodes []LinkGraphNode `json:"nodes"`
+	Edges []LinkGraphEdge `json:"edges"`
+}
+
+type TocEntry struct {
+	Level  string `json:"level"` // 1 to 6
+	Text   string `json:"text"`
+	Anchor string `json:"anchor"` // id of the heading in the rendered HTML
+}
+
+type RenderedNote struct {
+	Revision string     `json:"revision"` // sha256 of the content it was rendered from
+	Html     string     `json:"html"`
+	Toc      []TocEntry `json:"toc"`
+}
+
+// Describes the contents of an export archive, alongside the files themselves
+type ExportManifest struct {
+	Version    string         `json:"version"`
+	Owner      string         `json:"owner"`
+	Folder     string         `json:"folder,omitempty"` // only notes under it were exported
+	ExportedAt string         `json:"exported_at"`      // unix time
+	Notes      []ExportedNote `json:"notes"`
+}
+
+type ExportedNote struct {
+	NoteMetadata
+	Path        string               `json:"path"` // within the archive
+	Attachments []ExportedAttachment `json:"attachments"`
+}
+
+type ExportedAttachment struct {
+	Attachment
+	Path string `json:"path"` // within the archive
+}
+
+type ImportResult struct {
+	Path         string `json:"path"`                    // within the archive
+	NoteName     string `json:"note_name,omitempty"`     // for notes
+	AttachmentId string `json:"attachment_id,omitempty"` // for attachments
+	Status       string `json:"status"`                  // "created", "conflict", "skipped" or "failed"
+	Error        string `json:"error,omitempty"`
+}
+
+type NoteTemplate struct {
+	Id        string `json:"template_id"` // id of the template's note
+	NoteName  string `json:"note_name"`
+	Owner     string `json:"owner"`
+	Shared    bool   `json:"shared"`
+	CreatedAt string `json:"created_at"` // unix time
+}
+
+type ApiToken struct {
+	Id         string   `json:"token_id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`             // unix time
+	ExpiresAt  string   `json:"expires_at,omitempty"`   // unix time; never expires if empty
+	LastUsedAt string   `json:"last_used_at,omitempty"` // unix time
+}
+
+type LoginAttempt struct {
+	Id          string `json:"attempt_id"`
+	Username    string `json:"username"` // as given; the user may not exist
+	Ip          string `json:"ip"`
+	Reason      string `json:"reason"`       // e.g. "invalid credentials", "throttled"
+	AttemptedAt string `json:"attempted_at"` // unix time
+}
+
+type User struct {
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	Disabled   bool   `json:"disabled"`
+	DisabledAt string `json:"disabled_at,omitempty"` // unix time
+	NoteCount  string `json:"note_count"`
+}
+
+type Invitation struct {
+	Id        string `json:"invitation_id"`
+	Role      string `json:"role"`
+	CreatedBy string `json:"created_by,omitempty"` // empty once that admin is deleted
+	CreatedAt string `json:"created_at"`           // unix time
+	ExpiresAt string `json:"expires_at"`           // unix time
 }
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// {{name}}, allowing for whitespace inside the braces
+var templateVarRe = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+type TemplateVars struct {
+	Title  string // of the note being created
+	Author string
+	Now    time.Time
+}
+
+// Fills in `{{date}}`, `{{time}}`, `{{author}}` and `{{title}}` in a
+// template. Anything else in double braces is left as it is.
+func ExpandTemplate(content string, vars TemplateVars) string {
+	values := map[string]string{
+		"date":   vars.Now.Format(time.DateOnly),
+		"time":   vars.Now.Format("15:04"),
+		"author": vars.Author,
+		"title":  vars.Title,
+	}
+
+	return templateVarRe.ReplaceAllStringFunc(content, func(m string) string {
+		name := strings.ToLower(templateVarRe.FindStringSubmatch(m)[1])
+
+		if v, ok := values[name]; ok {
+			return v
+		}
+
+		return m
+	})
+}
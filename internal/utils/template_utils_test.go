@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	vars := TemplateVars{
+		Title:  "Weekly sync",
+		Author: "alice",
+		Now:    time.Date(2025, 3, 7, 9, 5, 0, 0, time.UTC),
+	}
+
+	got := ExpandTemplate("# {{title}}\n{{ Date }} {{time}} by {{author}}, {{unknown}} {{title", vars)
+	expected := "# Weekly sync\n2025-03-07 09:05 by alice, {{unknown}} {{title"
+
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTotp(t *testing.T) {
+	// RFC 6238 test vectors, for SHA1 and truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, c := range cases {
+		code, err := TotpCode(secret, TotpStep(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != c.code {
+			t.Errorf("at %d: expected %s, got %s", c.unix, c.code, code)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+
+	if step, ok := ValidateTotp(secret, "081804", now.Add(30*time.Second)); !ok || step != TotpStep(now) {
+		t.Error("expected code from the previous step to be accepted")
+	}
+
+	if _, ok := ValidateTotp(secret, "081804", now.Add(90*time.Second)); ok {
+		t.Error("expected stale code to be rejected")
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const maxUsernameBytes = 64
+
+var ErrInvalidUsername = errors.New("invalid username")
+
+// Checks that `username` can be used as a user's name. Usernames name each
+// user's directory in storage, so they're kept to characters that are safe in
+// a path. Errors wrap ErrInvalidUsername and say which rule was broken.
+func ValidateUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("%w: mustn't be empty", ErrInvalidUsername)
+	}
+
+	if len(username) > maxUsernameBytes {
+		return fmt.Errorf("%w: must be at most %d bytes long", ErrInvalidUsername, maxUsernameBytes)
+	}
+
+	if strings.HasPrefix(username, ".") {
+		return fmt.Errorf("%w: mustn't start with a dot", ErrInvalidUsername)
+	}
+
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-@+", r) {
+			return fmt.Errorf("%w: may only contain letters, digits and any of ._-@+", ErrInvalidUsername)
+		}
+	}
+
+	return nil
+}
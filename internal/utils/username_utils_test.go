@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	cases := []struct {
+		username string
+		valid    bool
+	}{
+		{"", false},
+		{"alice", true},
+		{"jane.doe", true},
+		{"jane@example.com", true},
+		{"Zoë_2", true},
+		{".hidden", false},
+		{"..", false},
+		{"x/../bob", false},
+		{"../foo", false},
+		{`a\b`, false},
+		{"with space", false},
+		{strings.Repeat("a", maxUsernameBytes), true},
+		{strings.Repeat("a", maxUsernameBytes+1), false},
+	}
+
+	for _, c := range cases {
+		err := ValidateUsername(c.username)
+		if c.valid && err != nil {
+			t.Errorf("expected %q to be accepted, got %v", c.username, err)
+		}
+		if !c.valid && !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("expected %q to be rejected, got %v", c.username, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/musannif-md/musannif/internal/logger"
+)
+
+const (
+	noteExt = ".md"
+
+	// Editors tend to write a file in several steps; wait for them to settle
+	debounce = 250 * time.Millisecond
+)
+
+// Receives the full content of a note that changed on disk
+type ChangeFunc func(owner, name string, content []byte)
+
+// Receives the name of something that's gone from disk: a note, a folder that
+// may have held notes, or any other file
+type RemoveFunc func(owner, name string)
+
+type watcher struct {
+	root     string
+	fw       *fsnotify.Watcher
+	onChange ChangeFunc
+	onRemove RemoveFunc
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// Watches every note under `root` (laid out as <root>/<owner>/<name>.md) until
+// `ctx` is done. Hidden files and directories are ignored.
+func Watch(ctx context.Context, root string, onChange ChangeFunc, onRemove RemoveFunc) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve note directory: %w", err)
+	}
+
+	err = os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error initializing note directory: %w", err)
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	w := &watcher{
+		root:     root,
+		fw:       fw,
+		onChange: onChange,
+		onRemove: onRemove,
+		timers:   make(map[string]*time.Timer),
+	}
+
+	// fsnotify isn't recursive, so every directory is watched separately
+	err = w.addTree(root)
+	if err != nil {
+		fw.Close()
+		return err
+	}
+
+	go w.loop(ctx)
+
+	return nil
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+func (w *watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		// Directories can disappear while walking
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != w.root && isHidden(d.Name()) {
+			return filepath.SkipDir
+		}
+
+		err = w.fw.Add(path)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+
+		return nil
+	})
+}
+
+func (w *watcher) loop(ctx context.Context) {
+	defer w.fw.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-w.fw.Events:
+			if !ok {
+				return
+			}
+			w.handle(ev)
+
+		case err, ok := <-w.fw.Errors:
+			if !ok {
+				return
+			}
+			logger.Log.Error().Err(err).Msg("note watcher error")
+		}
+	}
+}
+
+func (w *watcher) handle(ev fsnotify.Event) {
+	rel, err := filepath.Rel(w.root, ev.Name)
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, p := range parts {
+		if isHidden(p) {
+			return
+		}
+	}
+
+	// Moving a file away shows up as a rename of it
+	removed := ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)
+
+	// Renaming a file into place (as editors do on save) shows up as a create
+	if !removed && !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+		return
+	}
+
+	if ev.Has(fsnotify.Create) {
+		info, err := os.Stat(ev.Name)
+		if err == nil && info.IsDir() {
+			err = w.addTree(ev.Name)
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("failed to watch new note directory")
+			}
+			return
+		}
+	}
+
+	if len(parts) < 2 {
+		return
+	}
+
+	// Once it's gone, there's no telling whether a path was a folder of notes
+	if !removed && !strings.HasSuffix(rel, noteExt) {
+		return
+	}
+
+	w.schedule(ev.Name, parts[0], strings.Join(parts[1:], "/"))
+}
+
+func (w *watcher) schedule(path, owner, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t, ok := w.timers[path]; ok {
+		t.Reset(debounce)
+		return
+	}
+
+	w.timers[path] = time.AfterFunc(debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, path)
+		w.mu.Unlock()
+
+		// Editors may remove a note only to write it again, so it's checked
+		// for once things have settled
+		content, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			w.onRemove(owner, name)
+			return
+		}
+		if err != nil || !strings.HasSuffix(name, noteExt) {
+			return
+		}
+
+		w.onChange(owner, name, content)
+	})
+}
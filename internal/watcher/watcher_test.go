@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type change struct {
+	owner, name, content string
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan change, 8)
+	err := Watch(ctx, root, func(owner, name string, content []byte) {
+		changes <- change{owner, name, string(content)}
+	}, func(owner, name string) {
+		t.Errorf("unexpected removal of %s/%s", owner, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories created after watching started are picked up too
+	dir := filepath.Join(root, "username", "folder")
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	os.WriteFile(filepath.Join(root, "username", ".hidden.md"), []byte("ignored"), 0644)
+	os.WriteFile(filepath.Join(dir, "not-a-note.txt"), []byte("ignored"), 0644)
+
+	path := filepath.Join(dir, "note.md")
+	for _, content := range []string{"first", "second"} {
+		if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case c := <-changes:
+		expected := change{"username", "folder/note.md", "second"}
+		if c != expected {
+			t.Errorf("expected %+v, got %+v", expected, c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change reported")
+	}
+
+	select {
+	case c := <-changes:
+		t.Errorf("unexpected change %+v", c)
+	case <-time.After(2 * debounce):
+	}
+}
+
+func TestWatchRemove(t *testing.T) {
+	root := t.TempDir()
+
+	dir := filepath.Join(root, "username", "folder")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"note.md", "rewritten.md", "folder/moved.md"} {
+		if err := os.WriteFile(filepath.Join(root, "username", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan change, 8)
+	removals := make(chan string, 8)
+	err := Watch(ctx, root, func(owner, name string, content []byte) {
+		changes <- change{owner, name, string(content)}
+	}, func(owner, name string) {
+		removals <- owner + "/" + name
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Remove(filepath.Join(root, "username", "note.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving a folder away only tells about the folder
+	if err = os.Rename(dir, filepath.Join(t.TempDir(), "folder")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removed and written again, as some editors save
+	path := filepath.Join(root, "username", "rewritten.md")
+	os.Remove(path)
+	if err = os.WriteFile(path, []byte("saved"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := map[string]bool{}
+	for len(removed) < 2 {
+		select {
+		case name := <-removals:
+			removed[name] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected removals to be reported, got %v", removed)
+		}
+	}
+
+	if !removed["username/note.md"] || !removed["username/folder"] {
+		t.Errorf("unexpected removals %v", removed)
+	}
+
+	select {
+	case c := <-changes:
+		expected := change{"username", "rewritten.md", "saved"}
+		if c != expected {
+			t.Errorf("expected %+v, got %+v", expected, c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change reported for the rewritten note")
+	}
+
+	select {
+	case name := <-removals:
+		t.Errorf("unexpected removal of %s", name)
+	case <-time.After(2 * debounce):
+	}
+}